@@ -7,7 +7,9 @@ require (
 	github.com/emirpasic/gods v1.18.1
 	github.com/google/uuid v1.3.1
 	github.com/hdt3213/rdb v1.0.16
+	github.com/klauspost/compress v1.17.11
 	github.com/lithammer/shortuuid/v4 v4.0.0
+	github.com/pierrec/lz4/v4 v4.1.21
 	github.com/redis/go-redis/v9 v9.1.0
 	github.com/scylladb/termtables v0.0.0-20191203121021-c4c0b6d42ff4
 )
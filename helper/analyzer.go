@@ -0,0 +1,396 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+// AnalyzeConfig 分析任务的公共参数
+type AnalyzeConfig struct {
+	WorkDir     string
+	WorkDirName string
+	TopN        int
+	MaxDepth    int
+	Separators  []string
+	Port        int
+	// PrefixMode 前缀分析的切分方式: sep(按Separators切分), char(按字符切分)，默认sep
+	PrefixMode string
+	// RankBy 大KEY的排名方式: size, count, keylen，默认size
+	RankBy string
+	// RankGroups 大KEY另外按哪些维度分组排名: type, db
+	RankGroups []string
+	// ElementTopN 大KEY分析时每个hash、set、zset、list输出的最大元素数，0表示不输出元素明细
+	ElementTopN int
+	// TTLBucket 过期时间线的时间粒度: minute, hour，默认minute
+	TTLBucket string
+	// SlotNodes slot分析时规划的集群节点数，按节点数平均分配slot，0表示按数据源(集群每个节点一个RDB)统计
+	SlotNodes int
+	// TrendStore 不为空时将每个数据源的前缀和类型汇总追加到该文件，用于 -c trend 分析增长趋势
+	TrendStore string
+	// Instance 趋势数据中的实例名称，本地文件必须指定；从Redis导出的数据源默认使用节点地址
+	Instance string
+	// Parallel 同时分析的数据源数量，每个数据源的分析器状态常驻内存直到分析完成，内存占用随之增加
+	Parallel int
+	// Params 自定义分析器的参数，命令行通过 -param key=value 指定
+	Params map[string]string
+}
+
+// separators 返回切分前缀使用的分隔符，没有指定时按 : 切分
+func (cfg *AnalyzeConfig) separators() []string {
+	return defaultSeparators(cfg.Separators)
+}
+
+// CreateOutput 在工作目录中创建数据源对应的结果文件，如 dump.rdb 加后缀 -memory.csv 得到 dump-memory.csv
+// 同名的数据源会自动追加序号，自定义分析器应使用它创建结果文件
+func (cfg *AnalyzeConfig) CreateOutput(src string, suffix string) (string, *os.File, error) {
+	return createOutPath(src, cfg.WorkDir, suffix, false)
+}
+
+// Analyzer 分析器，每次分析任务创建一个实例
+// 每个数据源调用一次Begin，所有数据源分析完后调用Close生成汇总结果
+// 并行分析时Begin和各数据源的FileAnalyzer会并发执行，跨数据源的共享状态需要自行加锁，
+// 一般在Finish中合并到Analyzer
+type Analyzer interface {
+	Begin(src string) (FileAnalyzer, error)
+	Close() ([]string, error)
+}
+
+// FileAnalyzer 单个数据源的分析，Add接收解析出的每个对象，Finish返回该数据源生成的结果文件
+// 结果文件会被打包到报告ZIP中
+type FileAnalyzer interface {
+	Add(object model.RedisObject)
+	Finish() ([]string, error)
+}
+
+// WebServer 分析结果需要通过Web展示的分析器，在报告打包后启动
+type WebServer interface {
+	Serve()
+}
+
+// AnalyzerFactory 根据分析参数创建分析器
+type AnalyzerFactory func(cfg *AnalyzeConfig) (Analyzer, error)
+
+type registeredAnalyzer struct {
+	title   string
+	factory AnalyzerFactory
+}
+
+var analyzers = struct {
+	sync.RWMutex
+	registry map[string]registeredAnalyzer
+}{registry: make(map[string]registeredAnalyzer)}
+
+// RegisterAnalyzer 注册分析器，注册后可以通过 -c name 使用，title用于输出任务名称
+// 一般在init中调用，名称为空、重复或factory为空时panic
+func RegisterAnalyzer(name string, title string, factory AnalyzerFactory) {
+	analyzers.Lock()
+	defer analyzers.Unlock()
+	if name == "" || strings.Contains(name, ",") || factory == nil {
+		panic(fmt.Sprintf("invalid analyzer: %q", name))
+	}
+	if _, ok := analyzers.registry[name]; ok {
+		panic(fmt.Sprintf("analyzer %s already registered", name))
+	}
+	analyzers.registry[name] = registeredAnalyzer{title: title, factory: factory}
+}
+
+// Analyzers 返回已注册的分析器名称，按名称排序
+func Analyzers() []string {
+	analyzers.RLock()
+	defer analyzers.RUnlock()
+	names := make([]string, 0, len(analyzers.registry))
+	for name := range analyzers.registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupAnalyzer(name string) (registeredAnalyzer, bool) {
+	analyzers.RLock()
+	defer analyzers.RUnlock()
+	a, ok := analyzers.registry[name]
+	return a, ok
+}
+
+func init() {
+	RegisterAnalyzer("memory", "内存分析", newMemoryAnalyzer)
+	RegisterAnalyzer("bigkey", "大KEY分析", newBigkeyAnalyzer)
+	RegisterAnalyzer("prefix", "前缀分析", newPrefixAnalyzer)
+	RegisterAnalyzer("flamegraph", "火焰图分析", newFlameAnalyzer)
+	RegisterAnalyzer("template", "KEY模板分析", newTemplateAnalyzer)
+	RegisterAnalyzer("ttl", "过期分析", newTTLAnalyzer)
+	RegisterAnalyzer("slot", "集群slot分布分析", newSlotAnalyzer)
+	RegisterAnalyzer("hashtag", "标签及跨slot分析", newHashtagAnalyzer)
+	RegisterAnalyzer("encoding", "编码优化建议", newEncodingAnalyzer)
+}
+
+// IsAnalyzeCommand 判断命令是否全部为已注册的分析器，多个命令用逗号分隔，如 memory,bigkey,prefix
+func IsAnalyzeCommand(cmd string) bool {
+	commands := splitCommands(cmd)
+	for _, c := range commands {
+		if _, ok := lookupAnalyzer(c); !ok {
+			return false
+		}
+	}
+	return len(commands) > 0
+}
+
+func splitCommands(cmd string) []string {
+	var commands []string
+	for _, c := range strings.Split(cmd, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			commands = append(commands, c)
+		}
+	}
+	return commands
+}
+
+// Analyse 每个RDB文件只解析一次，对象分发给所有分析器，所有分析结果打包到同一个ZIP
+func Analyse(cmd string, rdbFiles []string, cfg AnalyzeConfig, options ...interface{}) error {
+	commands := splitCommands(cmd)
+	var titles []string
+	var instances []Analyzer
+	for _, c := range commands {
+		registered, ok := lookupAnalyzer(c)
+		if !ok {
+			return fmt.Errorf("unknown analyze command: %s", c)
+		}
+		a, err := registered.factory(&cfg)
+		if err != nil {
+			return fmt.Errorf("create analyzer %s failed, %v", c, err)
+		}
+		titles = append(titles, registered.title)
+		instances = append(instances, a)
+	}
+	if len(instances) == 0 {
+		return errors.New("analyze command is required")
+	}
+	if len(rdbFiles) == 0 {
+		return errors.New("rdb files are required")
+	}
+	if cfg.TrendStore != "" {
+		if cfg.Instance != "" && len(rdbFiles) > 1 {
+			return errors.New("-instance 只能用于单个数据源")
+		}
+		for _, src := range rdbFiles {
+			if _, err := trendInstance(src, cfg.Instance); err != nil {
+				return err
+			}
+		}
+		instances = append(instances, newTrendRecorder(&cfg))
+	}
+
+	fmt.Printf("🔍 启动%s任务\n", strings.Join(titles, "、"))
+	fmt.Println("==========================================")
+	fmt.Printf("📁 工作目录: %s\n", cfg.WorkDir)
+	if cfg.TrendStore != "" {
+		fmt.Printf("📈 趋势数据: %s\n", cfg.TrendStore)
+	}
+	fmt.Printf("📊 分析文件数量: %d\n\n", len(rdbFiles))
+
+	var outputFiles []string // 用于收集生成的文件路径，后续压缩
+	results := analyseFiles(rdbFiles, instances, cfg.Parallel, options...)
+	count := 0
+	for _, result := range results {
+		// 按数据源顺序收集结果文件，保证报告内容稳定
+		outputFiles = append(outputFiles, result.files...)
+		count += result.count
+	}
+	for _, result := range results {
+		if result.err != nil {
+			return fmt.Errorf("❌ 分析RDB文件 %s 失败: %v", result.src, result.err)
+		}
+	}
+	for _, a := range instances {
+		files, err := a.Close()
+		outputFiles = append(outputFiles, files...)
+		if err != nil {
+			return fmt.Errorf("❌ 生成汇总结果失败: %v", err)
+		}
+		for _, file := range files {
+			fmt.Printf("  ✅ 汇总 -> %s\n", file)
+		}
+	}
+
+	if len(outputFiles) > 0 {
+		fmt.Println("\n📦 正在打包报告文件...")
+		zipPath := generateZipName(cfg.WorkDir, cfg.WorkDirName)
+		err := compressFiles(outputFiles, zipPath)
+		if err != nil {
+			fmt.Printf("❌ 压缩失败: %v\n", err)
+		} else {
+			fmt.Printf("✅ 压缩完成: %s\n", zipPath)
+			// 清理原始文件
+			cleanupFiles(outputFiles)
+		}
+	}
+
+	fmt.Println("==========================================")
+	fmt.Printf("🎉 %s任务完成，共分析 %d 个RDB文件，%d 个KEY\n", strings.Join(titles, "、"), len(rdbFiles), count)
+	for _, a := range instances {
+		if server, ok := a.(WebServer); ok {
+			server.Serve()
+		}
+	}
+	return nil
+}
+
+// fileResult 单个数据源的分析结果
+type fileResult struct {
+	src     string
+	files   []string
+	count   int
+	elapsed time.Duration
+	err     error
+}
+
+// analyseFiles 使用parallel个协程同时分析多个数据源，返回按数据源顺序排列的结果
+// 某个数据源失败后不再开始新的数据源，已开始的继续完成
+// 流式数据源分析时才从节点全量同步，同一主机上的节点不会同时分析，避免同时fork
+func analyseFiles(rdbFiles []string, analyzers []Analyzer, parallel int, options ...interface{}) []*fileResult {
+	if parallel <= 0 {
+		parallel = 1
+	}
+	if parallel > len(rdbFiles) {
+		parallel = len(rdbFiles)
+	}
+	if parallel > 1 {
+		fmt.Printf("⚡ 并行分析: %d\n\n", parallel)
+	}
+	results := make([]*fileResult, len(rdbFiles))
+	for i, rdbFilename := range rdbFiles {
+		results[i] = &fileResult{src: rdbFilename}
+	}
+	var mu sync.Mutex // 保护输出和failed，每个数据源的进度整行输出，避免交错
+	failed := false
+	finished := 0
+	jobs := make([]string, len(rdbFiles))
+	for i := range rdbFiles {
+		jobs[i] = strconv.Itoa(i)
+	}
+	scheduler := newHostSchedulerFunc(jobs, func(job string) string {
+		i, _ := strconv.Atoi(job)
+		if node, ok := streamNode(rdbFiles[i]); ok {
+			return nodeHost(node)
+		}
+		// 本地文件互不影响，每个文件单独作为一个主机
+		return "file " + job
+	})
+	scheduler.run(parallel, func(job string) {
+		i, _ := strconv.Atoi(job)
+		result := results[i]
+		mu.Lock()
+		if failed {
+			mu.Unlock()
+			return
+		}
+		if parallel > 1 {
+			fmt.Printf("[%d/%d] 开始分析: %s\n", i+1, len(rdbFiles), result.src)
+		} else {
+			fmt.Printf("[%d/%d] 正在分析: %s\n", i+1, len(rdbFiles), result.src)
+		}
+		mu.Unlock()
+
+		start := time.Now()
+		result.files, result.count, result.err = analyseFile(result.src, analyzers, options...)
+		result.elapsed = time.Since(start)
+
+		mu.Lock()
+		finished++
+		if result.err != nil {
+			failed = true
+			fmt.Printf("  ❌ [%d/%d] 分析失败: %s: %v\n", finished, len(rdbFiles), result.src, result.err)
+			for _, file := range result.files {
+				fmt.Printf("  ⚠️  已生成 -> %s\n", file)
+			}
+		} else {
+			if parallel > 1 {
+				fmt.Printf("  ✅ [%d/%d] 完成: %s (KEY: %d, 耗时: %s)\n",
+					finished, len(rdbFiles), result.src, result.count, result.elapsed.Round(time.Millisecond))
+			}
+			for _, file := range result.files {
+				fmt.Printf("  ✅ 完成 -> %s\n", file)
+			}
+		}
+		mu.Unlock()
+	})
+	return results
+}
+
+// fileAborter 解析失败时代替Finish调用，用于丢弃不完整的结果，如趋势数据不追加残缺的快照
+type fileAborter interface {
+	Abort(err error)
+}
+
+// abort 解析失败时结束分析器，未实现fileAborter的仍调用Finish关闭已创建的文件，并返回这些文件
+func abort(fa FileAnalyzer, err error) []string {
+	if a, ok := fa.(fileAborter); ok {
+		a.Abort(err)
+		return nil
+	}
+	files, _ := fa.Finish()
+	return files
+}
+
+// analyseFile 解析一个RDB文件，每个对象交给所有分析器，返回生成的结果文件和KEY数
+func analyseFile(rdbFilename string, analyzers []Analyzer, options ...interface{}) ([]string, int, error) {
+	if rdbFilename == "" {
+		return nil, 0, errors.New("src file path is required")
+	}
+	dec, rdbFile, err := openDecoder(rdbFilename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	if dec, err = wrapDecoder(dec, options...); err != nil {
+		return nil, 0, err
+	}
+	var fileAnalyzers []FileAnalyzer
+	for _, a := range analyzers {
+		fa, err := a.Begin(rdbFilename)
+		if err != nil {
+			var outputFiles []string
+			for _, begun := range fileAnalyzers {
+				outputFiles = append(outputFiles, abort(begun, err)...)
+			}
+			return outputFiles, 0, err
+		}
+		fileAnalyzers = append(fileAnalyzers, fa)
+	}
+	count := 0
+	parseErr := dec.Parse(func(object model.RedisObject) bool {
+		count++
+		for _, fa := range fileAnalyzers {
+			fa.Add(object)
+		}
+		return true
+	})
+	// 只有解析失败时才放弃结果，某个分析器Finish失败不影响其他分析器，已创建的结果文件都返回
+	var outputFiles []string
+	for _, fa := range fileAnalyzers {
+		if parseErr != nil {
+			outputFiles = append(outputFiles, abort(fa, parseErr)...)
+			continue
+		}
+		files, finishErr := fa.Finish()
+		outputFiles = append(outputFiles, files...)
+		if err == nil {
+			err = finishErr
+		}
+	}
+	if parseErr != nil {
+		err = parseErr
+	}
+	return outputFiles, count, err
+}
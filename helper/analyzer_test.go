@@ -0,0 +1,333 @@
+package helper
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+// writeTestAof 生成用于分析的AOF文件，避免依赖RDB样例
+func writeTestAof(t *testing.T, dir string, name string, cmds ...[]string) string {
+	var content string
+	for _, cmd := range cmds {
+		content += respCmd(cmd...)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func zipEntries(t *testing.T, zipPath string) []string {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	var names []string
+	for _, f := range reader.File {
+		names = append(names, f.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestAnalyseMultiple(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTestAof(t, dir, "node1.aof",
+		[]string{"SET", "user:1", "tom"},
+		[]string{"RPUSH", "list:1", "a", "b"},
+		[]string{"HSET", "user:2:info", "name", "jerry"},
+	)
+	workDir := filepath.Join(dir, "report")
+	_ = os.MkdirAll(workDir, os.ModePerm)
+	if !IsAnalyzeCommand("memory, bigkey,prefix") || IsAnalyzeCommand("memory,json") {
+		t.Error("wrong analyze command detection")
+	}
+	err := Analyse("memory,bigkey,prefix", []string{src}, AnalyzeConfig{WorkDir: workDir, WorkDirName: "report"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expect := []string{"node1-bigkey.csv", "node1-memory.csv", "node1-prefix.csv"}
+	if names := zipEntries(t, generateZipName(workDir, "report")); !slices.Equal(names, expect) {
+		t.Errorf("wrong report files: %v", names)
+	}
+}
+
+// countAnalyzer 统计每种类型的KEY数，用于验证自定义分析器的注册和生命周期
+type countAnalyzer struct {
+	cfg    *AnalyzeConfig
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+type countFileAnalyzer struct {
+	*countAnalyzer
+	counts map[string]int
+}
+
+func (a *countAnalyzer) Begin(src string) (FileAnalyzer, error) {
+	return countFileAnalyzer{a, make(map[string]int)}, nil
+}
+
+func (a *countAnalyzer) Close() ([]string, error) {
+	path, file, err := a.cfg.CreateOutput("all", "-"+a.cfg.Params["name"]+".txt")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	_, err = fmt.Fprintf(file, "string=%d list=%d\n", a.counts["string"], a.counts["list"])
+	return []string{path}, err
+}
+
+func (fa countFileAnalyzer) Add(object model.RedisObject) {
+	fa.counts[object.GetType()]++
+}
+
+func (fa countFileAnalyzer) Finish() ([]string, error) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	for t, n := range fa.counts {
+		fa.countAnalyzer.counts[t] += n
+	}
+	return nil, nil
+}
+
+func TestRegisterAnalyzer(t *testing.T) {
+	RegisterAnalyzer("test-count", "类型计数", func(cfg *AnalyzeConfig) (Analyzer, error) {
+		return &countAnalyzer{cfg: cfg, counts: make(map[string]int)}, nil
+	})
+	if !slices.Contains(Analyzers(), "test-count") {
+		t.Errorf("analyzer not registered: %v", Analyzers())
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expect panic on duplicate registration")
+			}
+		}()
+		RegisterAnalyzer("memory", "内存分析", newMemoryAnalyzer)
+	}()
+
+	dir := t.TempDir()
+	src1 := writeTestAof(t, dir, "a.aof", []string{"SET", "k1", "v"}, []string{"RPUSH", "l", "x"})
+	src2 := writeTestAof(t, dir, "b.aof", []string{"SET", "k2", "v"})
+	workDir := filepath.Join(dir, "report")
+	_ = os.MkdirAll(workDir, os.ModePerm)
+	cfg := AnalyzeConfig{WorkDir: workDir, WorkDirName: "report", Params: map[string]string{"name": "types"}}
+	if err := Analyse("test-count,memory", []string{src1, src2}, cfg); err != nil {
+		t.Error(err)
+		return
+	}
+	expect := []string{"a-memory.csv", "all-types.txt", "b-memory.csv"}
+	if names := zipEntries(t, generateZipName(workDir, "report")); !slices.Equal(names, expect) {
+		t.Errorf("wrong report files: %v", names)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "all-types.txt")); err == nil {
+		t.Errorf("report files should be cleaned up after packing")
+	}
+}
+
+func TestAnalyseParallel(t *testing.T) {
+	dir := t.TempDir()
+	var srcs []string
+	for i := 0; i < 6; i++ {
+		// 不同目录下的同名文件，结果文件名需要追加序号且不冲突
+		sub := filepath.Join(dir, strconv.Itoa(i))
+		_ = os.MkdirAll(sub, os.ModePerm)
+		srcs = append(srcs, writeTestAof(t, sub, "dump.aof",
+			[]string{"SET", "k" + strconv.Itoa(i), "v"},
+			[]string{"RPUSH", "l", "x", "y"},
+		))
+	}
+	workDir := filepath.Join(dir, "report")
+	_ = os.MkdirAll(workDir, os.ModePerm)
+	cfg := AnalyzeConfig{WorkDir: workDir, WorkDirName: "report", Parallel: 3}
+	if err := Analyse("bigkey,flamegraph-test", srcs, cfg); err == nil {
+		t.Error("expect error for unknown analyzer")
+	}
+	if err := Analyse("memory,bigkey", srcs, cfg); err != nil {
+		t.Error(err)
+		return
+	}
+	if names := zipEntries(t, generateZipName(workDir, "report")); len(names) != 13 {
+		t.Errorf("expect 12 report files and all-bigkey.csv, got %v", names)
+	}
+
+	_ = os.WriteFile(filepath.Join(dir, "bad.rdb"), []byte("REDIS0009\xfe"), 0644)
+	if err := Analyse("memory", append(srcs, filepath.Join(dir, "bad.rdb")), cfg); err == nil {
+		t.Error("expect error for broken rdb")
+	}
+}
+
+func TestAnalyseStreamPerHost(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	running := make(map[string]int)
+	overlap := false
+	var srcs []string
+	for _, node := range []string{"10.0.0.1:7000", "10.0.0.1:7001", "10.0.0.2:7000", "10.0.0.2:7001"} {
+		src := dumpPath(dir, node)
+		host := nodeHost(node)
+		registerStreamSource(src, node, func() (io.ReadCloser, error) {
+			mu.Lock()
+			running[host]++
+			overlap = overlap || running[host] > 1
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			running[host]--
+			mu.Unlock()
+			// 只有结束标记和校验和的空RDB
+			return io.NopCloser(strings.NewReader("REDIS0009\xff\x00\x00\x00\x00\x00\x00\x00\x00")), nil
+		})
+		srcs = append(srcs, src)
+	}
+	workDir := filepath.Join(dir, "report")
+	_ = os.MkdirAll(workDir, os.ModePerm)
+	a, _ := newMemoryAnalyzer(&AnalyzeConfig{WorkDir: workDir})
+	for _, result := range analyseFiles(srcs, []Analyzer{a}, 4) {
+		if result.err != nil {
+			t.Error(result.err)
+		}
+	}
+	if overlap {
+		t.Error("nodes on the same host should not be streamed at the same time")
+	}
+}
+
+func TestBigkeyMerged(t *testing.T) {
+	dir := t.TempDir()
+	srcs := []string{
+		writeTestAof(t, dir, "redis-dump-10.0.0.1-6379.rdb.aof",
+			[]string{"SET", "small", "v"},
+			[]string{"SET", "big1", strings.Repeat("x", 1000)},
+		),
+		writeTestAof(t, dir, "node2.aof",
+			[]string{"SET", "big2", strings.Repeat("x", 2000)},
+			[]string{"SET", "mid", strings.Repeat("x", 100)},
+		),
+	}
+	workDir := filepath.Join(dir, "report")
+	_ = os.MkdirAll(workDir, os.ModePerm)
+	cfg := AnalyzeConfig{WorkDir: workDir, WorkDirName: "report", TopN: 2, Parallel: 2}
+	a, _ := newBigkeyAnalyzer(&cfg)
+	if _, _, err := analyseFile(srcs[0], []Analyzer{a}); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, _, err := analyseFile(srcs[1], []Analyzer{a}); err != nil {
+		t.Error(err)
+		return
+	}
+	files, err := a.Close()
+	if err != nil || len(files) != 1 || filepath.Base(files[0]) != "all-bigkey.csv" {
+		t.Errorf("wrong merged output: %v, %v", files, err)
+		return
+	}
+	content, _ := os.ReadFile(files[0])
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 3 || !strings.HasSuffix(lines[0], ",source") ||
+		!strings.HasPrefix(lines[1], "0,big2,") || !strings.HasSuffix(lines[1], ","+srcs[1]) ||
+		!strings.HasPrefix(lines[2], "0,big1,") {
+		t.Errorf("wrong merged ranking:\n%s", content)
+	}
+}
+
+func TestSourceLabel(t *testing.T) {
+	cases := map[string]string{
+		"/tmp/work/redis-dump-10.0.0.1-6379.rdb":      "10.0.0.1:6379",
+		"/tmp/work/redis-dump-redis-a.local-7000.rdb": "redis-a.local:7000",
+		"/backups/dump.rdb":                           "/backups/dump.rdb",
+		"-":                                           "stdin",
+	}
+	for src, expect := range cases {
+		if got := sourceLabel(src); got != expect {
+			t.Errorf("%s: expect %s, got %s", src, expect, got)
+		}
+	}
+}
+
+func TestBigkeyRanking(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTestAof(t, dir, "node.aof",
+		[]string{"SET", "str", strings.Repeat("x", 1000)},
+		[]string{"RPUSH", "list:1", "a", "b", "c"},
+		[]string{"RPUSH", "list:2", "a"},
+		[]string{"SADD", "set", "a", "b"},
+		[]string{"SELECT", "1"},
+		[]string{"RPUSH", "a-very-long-list-name", "a", "b", "c", "d"},
+	)
+	workDir := filepath.Join(dir, "report")
+	_ = os.MkdirAll(workDir, os.ModePerm)
+	cfg := AnalyzeConfig{WorkDir: workDir, WorkDirName: "report", TopN: 1, RankBy: RankByCount,
+		RankGroups: []string{RankGroupType, RankGroupDB}}
+	a, err := newBigkeyAnalyzer(&cfg)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	files, _, err := analyseFile(src, []Analyzer{a})
+	if err != nil || len(files) != 3 {
+		t.Errorf("wrong output: %v, %v", files, err)
+		return
+	}
+	read := func(path string) []string {
+		content, _ := os.ReadFile(path)
+		return strings.Split(strings.TrimSpace(string(content)), "\n")
+	}
+	if lines := read(files[0]); len(lines) != 2 || !strings.HasPrefix(lines[1], "1,a-very-long-list-name,list,") {
+		t.Errorf("wrong count ranking: %v", lines)
+	}
+	expect := []string{"type,database,key,type,size,size_readable,element_count", "list,1,a-very-long-list-name,", "set,0,set,", "string,0,str,"}
+	if lines := read(files[1]); len(lines) != len(expect) || lines[0] != expect[0] ||
+		!strings.HasPrefix(lines[1], expect[1]) || !strings.HasPrefix(lines[2], expect[2]) || !strings.HasPrefix(lines[3], expect[3]) {
+		t.Errorf("wrong type ranking: %v", lines)
+	}
+	if lines := read(files[2]); len(lines) != 3 || !strings.HasPrefix(lines[1], "0,0,list:1,") || !strings.HasPrefix(lines[2], "1,1,") {
+		t.Errorf("wrong db ranking: %v", lines)
+	}
+
+	cfg.RankBy = "ttl"
+	if _, err := newBigkeyAnalyzer(&cfg); err == nil {
+		t.Error("expect error for unknown rank")
+	}
+}
+
+// failingAnalyzer Finish总是失败，用于验证其他分析器的结果文件仍被返回
+type failingAnalyzer struct{}
+
+func (a failingAnalyzer) Begin(src string) (FileAnalyzer, error) { return a, nil }
+func (a failingAnalyzer) Add(object model.RedisObject)           {}
+func (a failingAnalyzer) Finish() ([]string, error)              { return nil, fmt.Errorf("finish failed") }
+func (a failingAnalyzer) Close() ([]string, error)               { return nil, nil }
+
+func TestAnalyseFinishFailed(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTestAof(t, dir, "a.aof", []string{"SET", "k", "v"})
+	cfg := AnalyzeConfig{WorkDir: dir}
+	memory, err := newMemoryAnalyzer(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _, err := analyseFile(src, []Analyzer{failingAnalyzer{}, memory})
+	if err == nil || len(files) != 1 {
+		t.Errorf("wrong output: %v, %v", files, err)
+		return
+	}
+	if content, _ := os.ReadFile(files[0]); !strings.Contains(string(content), "k") {
+		t.Errorf("later analyzer should still finish: %q", content)
+	}
+}
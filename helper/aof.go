@@ -0,0 +1,908 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/memprofiler"
+	"github.com/hdt3213/rdb/model"
+)
+
+// aofDecoder 回放AOF生成最终的KEY状态，支持RDB前导(aof-use-rdb-preamble)和Redis 7的多文件AOF清单
+// 回放需要把所有KEY保存在内存中，AOF对应的实例有多大，内存就需要多少
+type aofDecoder struct {
+	files []string // 按回放顺序排列的文件，第一个可能是RDB格式的base文件
+	dbs   map[int]map[string]model.RedisObject
+	db    int
+	// 回放期间集合、有序集合的成员索引，避免重复成员的线性查找
+	setIndex  map[*model.SetObject]map[string]struct{}
+	zsetIndex map[*model.ZSetObject]map[string]*model.ZSetEntry
+	// 不支持回放的命令及次数
+	skipped map[string]int
+	now     time.Time
+}
+
+func newAofDecoder(files []string) *aofDecoder {
+	return &aofDecoder{
+		files:     files,
+		dbs:       make(map[int]map[string]model.RedisObject),
+		setIndex:  make(map[*model.SetObject]map[string]struct{}),
+		zsetIndex: make(map[*model.ZSetObject]map[string]*model.ZSetEntry),
+		skipped:   make(map[string]int),
+		now:       time.Now(),
+	}
+}
+
+const manifestSuffix = ".manifest"
+
+// isAofSrc 判断数据源是否为AOF文件或多文件AOF清单
+func isAofSrc(name string) bool {
+	if strings.HasSuffix(name, manifestSuffix) {
+		return true
+	}
+	if name == stdinSrc || !strings.HasSuffix(name, ".aof") {
+		return false
+	}
+	return true
+}
+
+// parseManifest 解析Redis 7的AOF清单，返回base文件和按seq排序的incr文件，history文件忽略
+// 格式: file appendonly.aof.1.base.rdb seq 1 type b
+func parseManifest(manifestPath string) ([]string, error) {
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest %s failed, %v", manifestPath, err)
+	}
+	type aofFile struct {
+		name string
+		seq  int
+		kind string
+	}
+	var base *aofFile
+	var incrs []aofFile
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(strings.TrimSpace(line))
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		f := aofFile{}
+		for i := 0; i+1 < len(fields); i += 2 {
+			value := strings.Trim(fields[i+1], `"`)
+			switch fields[i] {
+			case "file":
+				f.name = value
+			case "seq":
+				f.seq, _ = strconv.Atoi(value)
+			case "type":
+				f.kind = value
+			}
+		}
+		if f.name == "" {
+			return nil, fmt.Errorf("invalid manifest line: %s", line)
+		}
+		switch f.kind {
+		case "b":
+			base = &f
+		case "i":
+			incrs = append(incrs, f)
+		}
+	}
+	sort.Slice(incrs, func(i, j int) bool {
+		return incrs[i].seq < incrs[j].seq
+	})
+	dir := filepath.Dir(manifestPath)
+	var files []string
+	if base != nil {
+		files = append(files, filepath.Join(dir, base.name))
+	}
+	for _, f := range incrs {
+		files = append(files, filepath.Join(dir, f.name))
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no aof file in manifest %s", manifestPath)
+	}
+	return files, nil
+}
+
+// findManifest 返回目录中的AOF清单，没有时返回空
+func findManifest(dir string) string {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"+manifestSuffix))
+	if len(matches) == 0 {
+		return ""
+	}
+	sort.Strings(matches)
+	return matches[0]
+}
+
+func (d *aofDecoder) Parse(cb func(object model.RedisObject) bool) error {
+	for _, name := range d.files {
+		if err := d.load(name); err != nil {
+			return err
+		}
+	}
+	if len(d.skipped) > 0 {
+		var cmds []string
+		for cmd, count := range d.skipped {
+			cmds = append(cmds, fmt.Sprintf("%s(%d)", cmd, count))
+		}
+		sort.Strings(cmds)
+		fmt.Printf("  ⚠️  AOF中以下命令不支持回放，已跳过: %s\n", strings.Join(cmds, ", "))
+	}
+
+	dbIndexes := make([]int, 0, len(d.dbs))
+	for db := range d.dbs {
+		dbIndexes = append(dbIndexes, db)
+	}
+	sort.Ints(dbIndexes)
+	for _, db := range dbIndexes {
+		keys := make([]string, 0, len(d.dbs[db]))
+		for key := range d.dbs[db] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			obj := d.dbs[db][key]
+			base := baseOf(obj)
+			base.Size = memprofiler.SizeOfObject(obj)
+			base.Type = obj.GetType()
+			if !cb(obj) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// load 回放一个文件，RDB格式的部分直接载入，之后的RESP命令逐条回放
+func (d *aofDecoder) load(name string) error {
+	file, err := openRdb(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// 载入RDB前导后会重新打开文件，关闭最后打开的句柄
+		_ = file.Close()
+	}()
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(len(rdbMagic))
+	if bytes.Equal(magic, rdbMagic) {
+		// 解码器内部有缓冲，读取位置不准，按解码器消费的字节数重新定位到RDB之后
+		rdbDecoder := core.NewDecoder(reader)
+		err = rdbDecoder.Parse(func(object model.RedisObject) bool {
+			d.set(object.GetDBIndex(), object.GetKey(), object)
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("parse rdb preamble of %s failed, %v", name, err)
+		}
+		_ = file.Close()
+		if file, err = openRdb(name); err != nil {
+			return err
+		}
+		reader = bufio.NewReader(file)
+		if _, err = io.CopyN(io.Discard, reader, int64(rdbDecoder.GetReadCount())); err != nil {
+			return fmt.Errorf("seek aof %s failed, %v", name, err)
+		}
+	}
+	for {
+		args, err := readRespCommand(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// 与aof-load-truncated一致，末尾不完整的命令忽略
+			fmt.Printf("  ⚠️  AOF %s 末尾命令不完整，已忽略: %v\n", filepath.Base(name), err)
+			return nil
+		}
+		d.apply(args)
+	}
+}
+
+// prependValues 与LPUSH相同，values依次插入到列表头部，结果中顺序相反，只分配一次
+func prependValues(list [][]byte, values [][]byte) [][]byte {
+	result := make([][]byte, len(values)+len(list))
+	for i, v := range values {
+		result[len(values)-1-i] = v
+	}
+	copy(result[len(values):], list)
+	return result
+}
+
+// readRespCommand 读取一条RESP数组格式的命令
+func readRespCommand(reader *bufio.Reader) ([][]byte, error) {
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) == 0 || line[0] != '*' {
+		return nil, fmt.Errorf("protocol error: %q", line)
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("protocol error: %q", line)
+	}
+	args := make([][]byte, n)
+	for i := range args {
+		line, err = reader.ReadBytes('\n')
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if len(line) < 3 || line[0] != '$' {
+			return nil, fmt.Errorf("protocol error: %q", line)
+		}
+		size, err := strconv.Atoi(string(bytes.TrimRight(line[1:], "\r\n")))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("protocol error: %q", line)
+		}
+		args[i] = make([]byte, size+2)
+		if _, err = io.ReadFull(reader, args[i]); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		args[i] = args[i][:size]
+	}
+	return args, nil
+}
+
+func baseOf(obj model.RedisObject) *model.BaseObject {
+	switch o := obj.(type) {
+	case *model.StringObject:
+		return o.BaseObject
+	case *model.ListObject:
+		return o.BaseObject
+	case *model.HashObject:
+		return o.BaseObject
+	case *model.SetObject:
+		return o.BaseObject
+	case *model.ZSetObject:
+		return o.BaseObject
+	case *model.StreamObject:
+		return o.BaseObject
+	case *model.ModuleTypeObject:
+		return o.BaseObject
+	}
+	return nil
+}
+
+func (d *aofDecoder) get(key string) model.RedisObject {
+	return d.dbs[d.db][key]
+}
+
+func (d *aofDecoder) set(db int, key string, obj model.RedisObject) {
+	if d.dbs[db] == nil {
+		d.dbs[db] = make(map[string]model.RedisObject)
+	}
+	d.dbs[db][key] = obj
+}
+
+func (d *aofDecoder) del(key string) bool {
+	obj, ok := d.dbs[d.db][key]
+	if !ok {
+		return false
+	}
+	delete(d.dbs[d.db], key)
+	switch o := obj.(type) {
+	case *model.SetObject:
+		delete(d.setIndex, o)
+	case *model.ZSetObject:
+		delete(d.zsetIndex, o)
+	}
+	return true
+}
+
+// touch 标记对象被修改：RDB中的紧凑编码信息已失效，改为按内容估算内存
+func touch(obj model.RedisObject) {
+	base := baseOf(obj)
+	base.Extra = nil
+	switch obj.(type) {
+	case *model.StringObject:
+		base.Encoding = model.StringEncoding
+	case *model.ListObject:
+		base.Encoding = model.ListEncoding
+	case *model.HashObject:
+		base.Encoding = model.HashEncoding
+	case *model.SetObject:
+		base.Encoding = model.SetEncoding
+	case *model.ZSetObject:
+		base.Encoding = model.ZSetEncoding
+	}
+}
+
+func (d *aofDecoder) newBase(key string) *model.BaseObject {
+	return &model.BaseObject{DB: d.db, Key: key}
+}
+
+// list 获取列表对象，create为true时不存在则新建，类型不符时返回nil，hash/setObj/zset同理
+func (d *aofDecoder) list(key string, create bool) *model.ListObject {
+	obj := d.get(key)
+	if obj == nil {
+		if !create {
+			return nil
+		}
+		o := &model.ListObject{BaseObject: d.newBase(key)}
+		d.set(d.db, key, o)
+		obj = o
+	}
+	o, _ := obj.(*model.ListObject)
+	if o != nil {
+		touch(o)
+	}
+	return o
+}
+
+func (d *aofDecoder) hash(key string, create bool) *model.HashObject {
+	obj := d.get(key)
+	if obj == nil {
+		if !create {
+			return nil
+		}
+		o := &model.HashObject{BaseObject: d.newBase(key), Hash: make(map[string][]byte)}
+		d.set(d.db, key, o)
+		obj = o
+	}
+	o, _ := obj.(*model.HashObject)
+	if o != nil {
+		touch(o)
+	}
+	return o
+}
+
+func (d *aofDecoder) setObj(key string, create bool) (*model.SetObject, map[string]struct{}) {
+	obj := d.get(key)
+	if obj == nil {
+		if !create {
+			return nil, nil
+		}
+		o := &model.SetObject{BaseObject: d.newBase(key)}
+		d.set(d.db, key, o)
+		obj = o
+	}
+	o, _ := obj.(*model.SetObject)
+	if o == nil {
+		return nil, nil
+	}
+	touch(o)
+	index, ok := d.setIndex[o]
+	if !ok {
+		index = make(map[string]struct{}, len(o.Members))
+		for _, m := range o.Members {
+			index[string(m)] = struct{}{}
+		}
+		d.setIndex[o] = index
+	}
+	return o, index
+}
+
+func (d *aofDecoder) zset(key string, create bool) (*model.ZSetObject, map[string]*model.ZSetEntry) {
+	obj := d.get(key)
+	if obj == nil {
+		if !create {
+			return nil, nil
+		}
+		o := &model.ZSetObject{BaseObject: d.newBase(key)}
+		d.set(d.db, key, o)
+		obj = o
+	}
+	o, _ := obj.(*model.ZSetObject)
+	if o == nil {
+		return nil, nil
+	}
+	touch(o)
+	index, ok := d.zsetIndex[o]
+	if !ok {
+		index = make(map[string]*model.ZSetEntry, len(o.Entries))
+		for _, e := range o.Entries {
+			index[e.Member] = e
+		}
+		d.zsetIndex[o] = index
+	}
+	return o, index
+}
+
+// setString 写入字符串，keepTTL为false时清除原有的过期时间
+func (d *aofDecoder) setString(key string, value []byte, expiration *time.Time, keepTTL bool) {
+	if keepTTL && expiration == nil {
+		if old := d.get(key); old != nil {
+			expiration = old.GetExpiration()
+		}
+	}
+	d.del(key)
+	base := d.newBase(key)
+	base.Expiration = expiration
+	o := &model.StringObject{BaseObject: base, Value: value}
+	touch(o)
+	d.set(d.db, key, o)
+}
+
+func (d *aofDecoder) expireAt(key string, at time.Time) {
+	if obj := d.get(key); obj != nil {
+		baseOf(obj).Expiration = &at
+	}
+}
+
+// removeIfEmpty 集合类对象为空时删除KEY，与Redis行为一致
+func (d *aofDecoder) removeIfEmpty(key string) {
+	obj := d.get(key)
+	if obj != nil && obj.GetType() != model.StringType && obj.GetElemCount() == 0 {
+		d.del(key)
+	}
+}
+
+func parseInt(b []byte) int64 {
+	n, _ := strconv.ParseInt(string(b), 10, 64)
+	return n
+}
+
+func msTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// ignoredCommands 对KEY状态没有影响的命令
+var ignoredCommands = map[string]bool{
+	"MULTI": true, "EXEC": true, "PING": true, "SCRIPT": true, "FUNCTION": true, "PUBLISH": true,
+}
+
+// apply 回放一条命令，只实现AOF中常见的写命令
+func (d *aofDecoder) apply(args [][]byte) {
+	cmd := strings.ToUpper(string(args[0]))
+	argc := len(args)
+	key := ""
+	if argc > 1 {
+		key = string(args[1])
+	}
+	ok := true
+	switch {
+	case ignoredCommands[cmd]:
+	case cmd == "SELECT" && argc == 2:
+		d.db = int(parseInt(args[1]))
+	case cmd == "FLUSHALL":
+		d.dbs = make(map[int]map[string]model.RedisObject)
+		d.setIndex = make(map[*model.SetObject]map[string]struct{})
+		d.zsetIndex = make(map[*model.ZSetObject]map[string]*model.ZSetEntry)
+	case cmd == "FLUSHDB":
+		for key := range d.dbs[d.db] {
+			d.del(key)
+		}
+	case cmd == "SWAPDB" && argc == 3:
+		a, b := int(parseInt(args[1])), int(parseInt(args[2]))
+		d.dbs[a], d.dbs[b] = d.dbs[b], d.dbs[a]
+		for _, db := range []int{a, b} {
+			for _, obj := range d.dbs[db] {
+				baseOf(obj).DB = db
+			}
+		}
+	case (cmd == "DEL" || cmd == "UNLINK") && argc >= 2:
+		for _, k := range args[1:] {
+			d.del(string(k))
+		}
+	case cmd == "SET" && argc >= 3:
+		d.applySet(key, args[2], args[3:])
+	case cmd == "SETEX" && argc == 4:
+		at := d.now.Add(time.Duration(parseInt(args[2])) * time.Second)
+		d.setString(key, args[3], &at, false)
+	case cmd == "PSETEX" && argc == 4:
+		at := d.now.Add(time.Duration(parseInt(args[2])) * time.Millisecond)
+		d.setString(key, args[3], &at, false)
+	case cmd == "SETNX" && argc == 3:
+		if d.get(key) == nil {
+			d.setString(key, args[2], nil, false)
+		}
+	case (cmd == "MSET" || cmd == "MSETNX") && argc >= 3 && argc%2 == 1:
+		for i := 1; i+1 < argc; i += 2 {
+			d.setString(string(args[i]), args[i+1], nil, false)
+		}
+	case cmd == "GETSET" && argc == 3:
+		d.setString(key, args[2], nil, false)
+	case cmd == "APPEND" && argc == 3:
+		var value []byte
+		if o, isStr := d.get(key).(*model.StringObject); isStr {
+			value = append(append([]byte{}, o.Value...), args[2]...)
+		} else {
+			value = args[2]
+		}
+		d.setString(key, value, nil, true)
+	case cmd == "INCR" || cmd == "DECR" || cmd == "INCRBY" || cmd == "DECRBY":
+		delta := int64(1)
+		if argc == 3 {
+			delta = parseInt(args[2])
+		}
+		if strings.HasPrefix(cmd, "DECR") {
+			delta = -delta
+		}
+		var value int64
+		if o, isStr := d.get(key).(*model.StringObject); isStr {
+			value = parseInt(o.Value)
+		}
+		d.setString(key, []byte(strconv.FormatInt(value+delta, 10)), nil, true)
+	case cmd == "EXPIRE" && argc >= 3:
+		d.expireAt(key, d.now.Add(time.Duration(parseInt(args[2]))*time.Second))
+	case cmd == "PEXPIRE" && argc >= 3:
+		d.expireAt(key, d.now.Add(time.Duration(parseInt(args[2]))*time.Millisecond))
+	case cmd == "EXPIREAT" && argc >= 3:
+		d.expireAt(key, time.Unix(parseInt(args[2]), 0))
+	case cmd == "PEXPIREAT" && argc >= 3:
+		d.expireAt(key, msTime(parseInt(args[2])))
+	case cmd == "PERSIST" && argc == 2:
+		if obj := d.get(key); obj != nil {
+			baseOf(obj).Expiration = nil
+		}
+	case (cmd == "RENAME" || cmd == "RENAMENX") && argc == 3:
+		newKey := string(args[2])
+		obj := d.get(key)
+		if obj == nil || (cmd == "RENAMENX" && d.get(newKey) != nil) {
+			break
+		}
+		delete(d.dbs[d.db], key)
+		d.del(newKey)
+		baseOf(obj).Key = newKey
+		d.set(d.db, newKey, obj)
+	case cmd == "MOVE" && argc == 3:
+		obj := d.get(key)
+		target := int(parseInt(args[2]))
+		if obj == nil || d.dbs[target][key] != nil {
+			break
+		}
+		delete(d.dbs[d.db], key)
+		baseOf(obj).DB = target
+		d.set(target, key, obj)
+	case (cmd == "RPUSH" || cmd == "LPUSH" || cmd == "RPUSHX" || cmd == "LPUSHX") && argc >= 3:
+		l := d.list(key, !strings.HasSuffix(cmd, "X"))
+		if l == nil {
+			break
+		}
+		if cmd[0] == 'R' {
+			l.Values = append(l.Values, args[2:]...)
+		} else {
+			l.Values = prependValues(l.Values, args[2:])
+		}
+	case (cmd == "LPOP" || cmd == "RPOP") && argc >= 2:
+		l := d.list(key, false)
+		if l == nil {
+			break
+		}
+		count := 1
+		if argc == 3 {
+			count = int(parseInt(args[2]))
+		}
+		count = min(count, len(l.Values))
+		if cmd == "LPOP" {
+			l.Values = l.Values[count:]
+		} else {
+			l.Values = l.Values[:len(l.Values)-count]
+		}
+		d.removeIfEmpty(key)
+	case cmd == "LSET" && argc == 4:
+		l := d.list(key, false)
+		if l == nil {
+			break
+		}
+		if i := listIndex(parseInt(args[2]), len(l.Values)); i >= 0 && i < len(l.Values) {
+			l.Values[i] = args[3]
+		}
+	case cmd == "LTRIM" && argc == 4:
+		l := d.list(key, false)
+		if l == nil {
+			break
+		}
+		start := max(listIndex(parseInt(args[2]), len(l.Values)), 0)
+		stop := min(listIndex(parseInt(args[3]), len(l.Values)), len(l.Values)-1)
+		if start > stop {
+			l.Values = nil
+		} else {
+			l.Values = l.Values[start : stop+1]
+		}
+		d.removeIfEmpty(key)
+	case cmd == "LREM" && argc == 4:
+		l := d.list(key, false)
+		if l == nil {
+			break
+		}
+		l.Values = listRemove(l.Values, int(parseInt(args[2])), args[3])
+		d.removeIfEmpty(key)
+	case cmd == "LINSERT" && argc == 5:
+		l := d.list(key, false)
+		if l == nil {
+			break
+		}
+		for i, v := range l.Values {
+			if bytes.Equal(v, args[3]) {
+				if strings.ToUpper(string(args[2])) == "AFTER" {
+					i++
+				}
+				l.Values = append(l.Values[:i], append([][]byte{args[4]}, l.Values[i:]...)...)
+				break
+			}
+		}
+	case (cmd == "LMOVE" && argc == 5) || (cmd == "RPOPLPUSH" && argc == 3):
+		from, to := "RIGHT", "LEFT"
+		if cmd == "LMOVE" {
+			from, to = strings.ToUpper(string(args[3])), strings.ToUpper(string(args[4]))
+		}
+		src := d.list(key, false)
+		if src == nil || len(src.Values) == 0 {
+			break
+		}
+		var v []byte
+		if from == "LEFT" {
+			v, src.Values = src.Values[0], src.Values[1:]
+		} else {
+			v, src.Values = src.Values[len(src.Values)-1], src.Values[:len(src.Values)-1]
+		}
+		d.removeIfEmpty(key)
+		dst := d.list(string(args[2]), true)
+		if dst == nil {
+			break
+		}
+		if to == "LEFT" {
+			dst.Values = prependValues(dst.Values, [][]byte{v})
+		} else {
+			dst.Values = append(dst.Values, v)
+		}
+	case (cmd == "HSET" || cmd == "HMSET") && argc >= 4 && argc%2 == 0:
+		if h := d.hash(key, true); h != nil {
+			for i := 2; i+1 < argc; i += 2 {
+				h.Hash[string(args[i])] = args[i+1]
+			}
+		}
+	case cmd == "HSETNX" && argc == 4:
+		if h := d.hash(key, true); h != nil {
+			if _, exists := h.Hash[string(args[2])]; !exists {
+				h.Hash[string(args[2])] = args[3]
+			}
+		}
+	case cmd == "HINCRBY" && argc == 4:
+		if h := d.hash(key, true); h != nil {
+			field := string(args[2])
+			h.Hash[field] = []byte(strconv.FormatInt(parseInt(h.Hash[field])+parseInt(args[3]), 10))
+		}
+	case cmd == "HDEL" && argc >= 3:
+		if h := d.hash(key, false); h != nil {
+			for _, f := range args[2:] {
+				delete(h.Hash, string(f))
+			}
+			d.removeIfEmpty(key)
+		}
+	case cmd == "SADD" && argc >= 3:
+		if s, index := d.setObj(key, true); s != nil {
+			for _, m := range args[2:] {
+				if _, exists := index[string(m)]; !exists {
+					index[string(m)] = struct{}{}
+					s.Members = append(s.Members, m)
+				}
+			}
+		}
+	case cmd == "SREM" && argc >= 3:
+		if s, index := d.setObj(key, false); s != nil {
+			d.setRemove(s, index, args[2:])
+			d.removeIfEmpty(key)
+		}
+	case cmd == "SMOVE" && argc == 4:
+		src, srcIndex := d.setObj(key, false)
+		if src == nil {
+			break
+		}
+		if _, exists := srcIndex[string(args[3])]; !exists {
+			break
+		}
+		d.setRemove(src, srcIndex, args[3:])
+		d.removeIfEmpty(key)
+		if dst, dstIndex := d.setObj(string(args[2]), true); dst != nil {
+			if _, exists := dstIndex[string(args[3])]; !exists {
+				dstIndex[string(args[3])] = struct{}{}
+				dst.Members = append(dst.Members, args[3])
+			}
+		}
+	case cmd == "ZADD" && argc >= 4:
+		d.applyZAdd(key, args[2:])
+	case cmd == "ZINCRBY" && argc == 4:
+		if z, index := d.zset(key, true); z != nil {
+			incr, _ := strconv.ParseFloat(string(args[2]), 64)
+			member := string(args[3])
+			if e, exists := index[member]; exists {
+				e.Score += incr
+			} else {
+				e = &model.ZSetEntry{Member: member, Score: incr}
+				index[member] = e
+				z.Entries = append(z.Entries, e)
+			}
+		}
+	case cmd == "ZREM" && argc >= 3:
+		if z, index := d.zset(key, false); z != nil {
+			removed := make(map[string]bool)
+			for _, m := range args[2:] {
+				removed[string(m)] = true
+				delete(index, string(m))
+			}
+			entries := z.Entries[:0]
+			for _, e := range z.Entries {
+				if !removed[e.Member] {
+					entries = append(entries, e)
+				}
+			}
+			z.Entries = entries
+			d.removeIfEmpty(key)
+		}
+	default:
+		ok = false
+	}
+	if !ok {
+		d.skipped[cmd]++
+	}
+}
+
+// applySet 回放 SET key value [NX|XX] [GET] [EX s|PX ms|EXAT ts|PXAT ms|KEEPTTL]
+func (d *aofDecoder) applySet(key string, value []byte, opts [][]byte) {
+	var expiration *time.Time
+	keepTTL := false
+	exists := d.get(key) != nil
+	for i := 0; i < len(opts); i++ {
+		opt := strings.ToUpper(string(opts[i]))
+		switch opt {
+		case "NX":
+			if exists {
+				return
+			}
+		case "XX":
+			if !exists {
+				return
+			}
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if i+1 >= len(opts) {
+				return
+			}
+			i++
+			n := parseInt(opts[i])
+			var at time.Time
+			switch opt {
+			case "EX":
+				at = d.now.Add(time.Duration(n) * time.Second)
+			case "PX":
+				at = d.now.Add(time.Duration(n) * time.Millisecond)
+			case "EXAT":
+				at = time.Unix(n, 0)
+			case "PXAT":
+				at = msTime(n)
+			}
+			expiration = &at
+		}
+	}
+	d.setString(key, value, expiration, keepTTL)
+}
+
+// applyZAdd 回放 ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member ...
+func (d *aofDecoder) applyZAdd(key string, args [][]byte) {
+	var nx, xx, gt, lt, incr bool
+	i := 0
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		case "INCR":
+			incr = true
+		case "CH":
+		default:
+			goto pairs
+		}
+	}
+pairs:
+	args = args[i:]
+	if len(args) == 0 || len(args)%2 != 0 {
+		d.skipped["ZADD"]++
+		return
+	}
+	z, index := d.zset(key, !xx)
+	if z == nil {
+		return
+	}
+	for j := 0; j+1 < len(args); j += 2 {
+		score, err := strconv.ParseFloat(string(args[j]), 64)
+		if err != nil {
+			continue
+		}
+		member := string(args[j+1])
+		e, exists := index[member]
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		if !exists {
+			e = &model.ZSetEntry{Member: member, Score: score}
+			index[member] = e
+			z.Entries = append(z.Entries, e)
+			continue
+		}
+		if incr {
+			score += e.Score
+		}
+		if (gt && score <= e.Score) || (lt && score >= e.Score) {
+			continue
+		}
+		e.Score = score
+	}
+	d.removeIfEmpty(key)
+}
+
+func (d *aofDecoder) setRemove(s *model.SetObject, index map[string]struct{}, members [][]byte) {
+	removed := make(map[string]bool)
+	for _, m := range members {
+		if _, exists := index[string(m)]; exists {
+			removed[string(m)] = true
+			delete(index, string(m))
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+	kept := s.Members[:0]
+	for _, m := range s.Members {
+		if !removed[string(m)] {
+			kept = append(kept, m)
+		}
+	}
+	s.Members = kept
+}
+
+// listIndex 将负数下标转换为正数下标
+func listIndex(i int64, length int) int {
+	if i < 0 {
+		return length + int(i)
+	}
+	return int(i)
+}
+
+// listRemove 实现LREM：count>0从头删除，count<0从尾删除，count==0删除全部
+func listRemove(values [][]byte, count int, value []byte) [][]byte {
+	if count < 0 {
+		removed := 0
+		for i := len(values) - 1; i >= 0 && removed < -count; i-- {
+			if bytes.Equal(values[i], value) {
+				values = append(values[:i], values[i+1:]...)
+				removed++
+			}
+		}
+		return values
+	}
+	kept := values[:0]
+	removed := 0
+	for _, v := range values {
+		if bytes.Equal(v, value) && (count == 0 || removed < count) {
+			removed++
+			continue
+		}
+		kept = append(kept, v)
+	}
+	return kept
+}
+
+var errNotAof = errors.New("not an aof source")
+
+// aofFiles 返回AOF数据源按回放顺序排列的文件：清单展开为base+incr，单个AOF文件原样返回
+func aofFiles(name string) ([]string, error) {
+	if strings.HasSuffix(name, manifestSuffix) {
+		return parseManifest(name)
+	}
+	if isAofSrc(name) {
+		return []string{name}, nil
+	}
+	return nil, errNotAof
+}
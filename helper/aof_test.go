@@ -0,0 +1,136 @@
+package helper
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+func respCmd(args ...string) string {
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return buf.String()
+}
+
+func TestAofManifest(t *testing.T) {
+	dir := t.TempDir()
+	// base使用RDB前导格式，checksum为0表示不校验
+	base := string(fakeRdb) + strings.Repeat("\x00", 8) +
+		respCmd("RPUSH", "list", "a", "b", "c") +
+		respCmd("SADD", "set", "x", "y") +
+		respCmd("SET", "tmp", "1", "EX", "100")
+	incr1 := respCmd("SELECT", "0") +
+		respCmd("LPOP", "list") +
+		respCmd("SADD", "set", "y", "z") +
+		respCmd("ZADD", "rank", "1", "m1", "2", "m2") +
+		respCmd("ZADD", "rank", "XX", "INCR", "5", "m1") +
+		respCmd("HSET", "user", "name", "tom", "age", "1")
+	incr2 := respCmd("HINCRBY", "user", "age", "2") +
+		respCmd("RENAME", "tmp", "kept") +
+		respCmd("PERSIST", "kept") +
+		respCmd("SELECT", "1") +
+		respCmd("SET", "other", "v") +
+		respCmd("XADD", "stream", "*", "f", "v") +
+		"*3\r\n$3\r\nSET\r\n$4\r\ntrun" // 末尾不完整的命令
+	manifest := "file appendonly.aof.1.base.aof seq 1 type b\n" +
+		"file appendonly.aof.0.incr.aof seq 0 type h\n" +
+		"file appendonly.aof.2.incr.aof seq 2 type i\n" +
+		"file \"appendonly.aof.1.incr.aof\" seq 1 type i\n"
+	files := map[string]string{
+		"appendonly.aof.1.base.aof": base,
+		"appendonly.aof.1.incr.aof": incr1,
+		"appendonly.aof.2.incr.aof": incr2,
+		"appendonly.aof.manifest":   manifest,
+	}
+	for name, content := range files {
+		_ = os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	srcs, err := ExpandSources(dir)
+	if err != nil || !slices.Equal(srcs, []string{filepath.Join(dir, "appendonly.aof.manifest")}) {
+		t.Errorf("wrong directory expansion: %v, %v", srcs, err)
+		return
+	}
+	dec, closer, err := openDecoder(srcs[0])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer closer.Close()
+	objects := make(map[string]model.RedisObject)
+	var keys []string
+	err = dec.Parse(func(object model.RedisObject) bool {
+		name := fmt.Sprintf("%d:%s", object.GetDBIndex(), object.GetKey())
+		keys = append(keys, name)
+		objects[name] = object
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !slices.Equal(keys, []string{"0:kept", "0:list", "0:rank", "0:set", "0:user", "1:other"}) {
+		t.Errorf("wrong keys: %v", keys)
+		return
+	}
+	if list := objects["0:list"].(*model.ListObject); len(list.Values) != 2 || string(list.Values[0]) != "b" {
+		t.Errorf("wrong list: %q", list.Values)
+	}
+	if set := objects["0:set"].(*model.SetObject); len(set.Members) != 3 {
+		t.Errorf("wrong set: %q", set.Members)
+	}
+	rank := objects["0:rank"].(*model.ZSetObject)
+	if len(rank.Entries) != 2 || rank.Entries[0].Score != 6 {
+		t.Errorf("wrong zset: %+v", rank.Entries)
+	}
+	if user := objects["0:user"].(*model.HashObject); string(user.Hash["age"]) != "3" {
+		t.Errorf("wrong hash: %q", user.Hash)
+	}
+	if kept := objects["0:kept"]; kept.GetExpiration() != nil || kept.GetSize() == 0 {
+		t.Errorf("wrong string: expiration %v, size %d", kept.GetExpiration(), kept.GetSize())
+	}
+}
+
+func TestListRemove(t *testing.T) {
+	values := func() [][]byte {
+		return [][]byte{[]byte("a"), []byte("b"), []byte("a"), []byte("a")}
+	}
+	cases := []struct {
+		count  int
+		expect string
+	}{
+		{1, "baa"},
+		{-2, "ab"},
+		{0, "b"},
+	}
+	for _, c := range cases {
+		var got string
+		for _, v := range listRemove(values(), c.count, []byte("a")) {
+			got += string(v)
+		}
+		if got != c.expect {
+			t.Errorf("LREM %d: expect %s, got %s", c.count, c.expect, got)
+		}
+	}
+}
+
+func TestPrependValues(t *testing.T) {
+	// LPUSH l c d 后 LPUSH l a b，结果为 b a d c
+	list := prependValues(nil, [][]byte{[]byte("c"), []byte("d")})
+	list = prependValues(list, [][]byte{[]byte("a"), []byte("b")})
+	var got string
+	for _, v := range list {
+		got += string(v)
+	}
+	if got != "badc" {
+		t.Errorf("expect badc, got %s", got)
+	}
+}
@@ -2,44 +2,71 @@ package helper
 
 import (
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/scylladb/termtables"
 )
 
+// dumpRetryInterval 导出失败后重试的基础间隔，第n次重试等待n倍间隔
+var dumpRetryInterval = 5 * time.Second
+
 type BgSave struct {
-	RedisServer string
-	Password    string
-	UseMaster   bool
-	WorkDir     string
-	NoDelete    bool
-	NoCluster   bool
-	DryRun      bool
-	Files       []string
-	tmpDir      string
+	RedisServer      string
+	Username         string
+	Password         string
+	UseMaster        bool
+	WorkDir          string
+	NoDelete         bool
+	NoCluster        bool
+	TLS              TLSOptions
+	SentinelUsername string
+	SentinelPassword string
+	DryRun           bool
+	Stream           bool   // 流式模式，RDB不落盘直接交给解码器
+	Tee              bool   // 流式模式下同时将RDB保存到工作目录
+	ReplicaPolicy    string // 从节点选择策略: lag/memory/zone
+	Zone             string // zone策略优先的可用区，CIDR网段或主机名中包含的字符串
+	Parallel         int    // 同时导出的节点数，同一主机上的节点不会同时导出
+	Retries          int    // 单个节点导出失败后的重试次数
+	Files            []string
+	tmpDir           string
 	*RedisConnection
 }
 
-func (s *BgSave) printNodes(masters []string, slaves []string) {
+func (s *BgSave) printNodes(shards []*Shard) {
 	t := termtables.CreateTable()
-	t.AddHeaders("Master节点", "Slave节点")
-	maxSize := max(len(masters), len(slaves))
-	for i := 0; i < maxSize; i++ {
-		col1 := ""
-		col2 := ""
-		if i+1 <= len(masters) {
-			col1 = masters[i]
+	t.AddHeaders("分片", "Master节点", "Slave节点")
+	for i, shard := range shards {
+		master := nodeStatus(shard.Master)
+		if len(shard.Replicas) == 0 {
+			t.AddRow(i+1, master, "")
+			continue
 		}
-		if i+1 <= len(slaves) {
-			col2 = slaves[i]
+		for j, replica := range shard.Replicas {
+			if j == 0 {
+				t.AddRow(i+1, master, nodeStatus(replica))
+			} else {
+				t.AddRow("", "", nodeStatus(replica))
+			}
 		}
-		t.AddRow(col1, col2)
 	}
 	fmt.Println(t.Render())
 }
 
+func nodeStatus(node ShardNode) string {
+	if node.Healthy {
+		return node.Addr
+	}
+	return node.Addr + " (异常)"
+}
+
 func (s *BgSave) connect() error {
 	fmt.Println("🔗 正在连接Redis服务器...")
 	err := s.RedisConnection.ConnectRedis()
@@ -59,44 +86,51 @@ func (s *BgSave) mkTmpDir() error {
 }
 
 func (s *BgSave) dump() error {
-	if s.UseMaster && len(s.Masters) == 0 {
-		return fmt.Errorf("❌ 错误: 用户选择使用Master节点进行分析，但没有可用的Master节点")
-	}
-	if !s.UseMaster && len(s.Slaves) == 0 {
-		return fmt.Errorf("❌ 错误: 用户选择使用Slave节点进行分析，但没有可用的Slave节点")
-	}
-	var nodes []string
 	var files []string
 	if s.UseMaster {
-		fmt.Printf("🎯 使用Master节点进行RDB导出 (%d个节点)\n", len(s.Masters))
-		nodes = s.Masters
+		fmt.Printf("🎯 使用Master节点进行RDB导出 (%d个分片)\n", len(s.Shards))
 	} else {
-		fmt.Printf("🎯 使用Slave节点进行RDB导出 (%d个节点)\n", len(s.Slaves))
-		nodes = s.Slaves
-	}
-
-	fmt.Println("📦 开始生成RDB文件...")
-	for i, node := range nodes {
-		fmt.Printf("  [%d/%d] 正在从 %s 导出RDB...", i+1, len(nodes), node)
-		nodeArr := strings.Split(node, ":")
-		host := nodeArr[0]
-		port := nodeArr[1]
-		rdbPath := fmt.Sprintf("%s/redis-dump-%s.rdb", s.tmpDir, strings.ReplaceAll(node, ":", "-"))
-		cmd := exec.Command("redis-cli", "-h", host, "-p", port, "-a", s.Password, "--no-auth-warning", "--rdb", rdbPath)
-		cmd.Stdout = nil // 不显示redis-cli的输出
-		cmd.Stderr = nil
-		err := cmd.Run()
-		if err != nil {
-			fmt.Printf(" ❌ 失败: %v\n", err)
-			continue
-		}
-		// 获取文件大小
-		if fileInfo, err := os.Stat(rdbPath); err == nil {
-			fmt.Printf(" ✅ 完成 (%.2fMB)\n", float64(fileInfo.Size())/1024/1024)
+		fmt.Printf("🎯 每个分片选择一个Slave节点进行RDB导出 (%d个分片, 策略: %s)\n", len(s.Shards), s.ReplicaPolicy)
+	}
+	nodes, err := s.SelectNodes(s.UseMaster, s.ReplicaPolicy, s.Zone)
+	if err != nil {
+		return fmt.Errorf("❌ 错误: %v", err)
+	}
+	fmt.Printf("✅ 选中节点: %s\n", strings.Join(nodes, ", "))
+
+	if s.Stream {
+		s.registerStreams(nodes)
+		return nil
+	}
+	for _, node := range nodes {
+		registerSourceInstance(dumpPath(s.tmpDir, node), s.shardName(node))
+	}
+
+	fmt.Printf("📦 开始生成RDB文件 (并发: %d, 重试: %d次)...\n", s.Parallel, s.Retries)
+	var mu sync.Mutex
+	var results []dumpResult
+	newHostScheduler(nodes).run(s.Parallel, func(node string) {
+		result := s.dumpWithRetry(node)
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, result)
+		if result.err != nil {
+			fmt.Printf("  [%d/%d] ❌ %s 导出失败: %v\n", len(results), len(nodes), node, result.err)
 		} else {
-			fmt.Println(" ✅ 完成")
+			fmt.Printf("  [%d/%d] ✅ %s 导出完成 (%.2fMB)\n", len(results), len(nodes), node, float64(result.size)/1024/1024)
+		}
+	})
+
+	// 按节点原始顺序输出汇总并收集文件
+	sort.Slice(results, func(i, j int) bool {
+		return slices.Index(nodes, results[i].node) < slices.Index(nodes, results[j].node)
+	})
+	fmt.Println("\n📊 导出结果:")
+	s.printResults(results)
+	for _, result := range results {
+		if result.err == nil {
+			files = append(files, result.path)
 		}
-		files = append(files, rdbPath)
 	}
 	if len(files) == 0 {
 		return fmt.Errorf("❌ 错误: 没有成功生成任何RDB文件")
@@ -106,6 +140,109 @@ func (s *BgSave) dump() error {
 	return nil
 }
 
+// dumpResult 单个节点的RDB导出结果
+type dumpResult struct {
+	node     string
+	path     string
+	size     int64
+	attempts int
+	elapsed  time.Duration
+	err      error
+}
+
+// dumpWithRetry 导出单个节点的RDB，失败时按重试次数重试，重试间隔逐次递增
+func (s *BgSave) dumpWithRetry(node string) dumpResult {
+	result := dumpResult{
+		node: node,
+		path: dumpPath(s.tmpDir, node),
+	}
+	start := time.Now()
+	for result.attempts <= s.Retries {
+		if result.attempts > 0 {
+			fmt.Printf("  🔁 %s 第%d次重试，上次错误: %v\n", node, result.attempts, result.err)
+			time.Sleep(time.Duration(result.attempts) * dumpRetryInterval)
+		}
+		result.attempts++
+		result.size, result.err = s.dumpNode(node, result.path)
+		if result.err == nil {
+			break
+		}
+	}
+	result.elapsed = time.Since(start)
+	return result
+}
+
+func (s *BgSave) printResults(results []dumpResult) {
+	t := termtables.CreateTable()
+	t.AddHeaders("节点", "状态", "大小", "尝试次数", "耗时", "错误")
+	for _, r := range results {
+		status, size, errMsg := "成功", fmt.Sprintf("%.2fMB", float64(r.size)/1024/1024), ""
+		if r.err != nil {
+			status, size, errMsg = "失败", "-", r.err.Error()
+		}
+		t.AddRow(r.node, status, size, r.attempts, r.elapsed.Round(time.Second).String(), errMsg)
+	}
+	fmt.Println(t.Render())
+}
+
+// registerStreams 流式模式下不导出RDB，只为每个节点注册流式数据源，分析时再从节点拉取
+func (s *BgSave) registerStreams(nodes []string) {
+	fmt.Printf("🌊 流式模式，RDB不落盘直接解析 (%d个节点)\n", len(nodes))
+	var files []string
+	for _, node := range nodes {
+		name := streamPath(node)
+		teePath := ""
+		if s.Tee {
+			teePath = dumpPath(s.tmpDir, node)
+		}
+		addr := node
+		conn := s.RedisConnection
+		registerStreamSource(name, node, func() (io.ReadCloser, error) {
+			return conn.streamRDB(addr, teePath)
+		})
+		registerSourceInstance(name, s.shardName(node))
+		files = append(files, name)
+	}
+	if s.Tee {
+		fmt.Println("💾 解析的同时将RDB保存到工作目录")
+	}
+	s.Files = files
+}
+
+// dumpFilePrefix 从Redis节点导出的RDB文件名前缀，文件名中包含节点地址
+const dumpFilePrefix = "redis-dump-"
+
+// streamScheme 流式数据源的虚拟路径前缀，与落盘的RDB文件路径区分
+const streamScheme = "stream://"
+
+// streamPath 节点流式数据源的虚拟路径，如 stream://10.0.0.1:6379/redis-dump-10.0.0.1-6379.rdb
+// 文件名与dumpPath相同，结果文件命名一致
+func streamPath(node string) string {
+	return streamScheme + node + "/" + filepath.Base(dumpPath("", node))
+}
+
+// dumpPath 节点RDB的保存路径，如 redis-dump-10.0.0.1-6379.rdb
+func dumpPath(dir string, node string) string {
+	return fmt.Sprintf("%s/%s%s.rdb", dir, dumpFilePrefix, strings.ReplaceAll(node, ":", "-"))
+}
+
+// dumpNode 通过复制协议拉取节点的RDB并写入rdbPath，失败时删除不完整的文件
+func (s *BgSave) dumpNode(node string, rdbPath string) (int64, error) {
+	rdbFile, err := os.Create(rdbPath)
+	if err != nil {
+		return 0, fmt.Errorf("创建RDB文件失败: %v", err)
+	}
+	size, err := s.RedisConnection.fetchRDB(node, rdbFile)
+	if closeErr := rdbFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(rdbPath)
+		return 0, err
+	}
+	return size, nil
+}
+
 func (s *BgSave) Clean() {
 	if s.NoDelete {
 		fmt.Println("🔒 保留工作目录 (用户指定)")
@@ -131,9 +268,20 @@ func (s *BgSave) Run() {
 
 	// 初始化Redis连接配置
 	s.RedisConnection = &RedisConnection{
-		RedisServer: s.RedisServer,
-		Password:    s.Password,
-		NoCluster:   s.NoCluster,
+		RedisServer:      s.RedisServer,
+		Username:         s.Username,
+		Password:         s.Password,
+		NoCluster:        s.NoCluster,
+		TLS:              s.TLS,
+		SentinelUsername: s.SentinelUsername,
+		SentinelPassword: s.SentinelPassword,
+	}
+
+	if s.Parallel <= 0 {
+		s.Parallel = 1
+	}
+	if s.ReplicaPolicy == "" {
+		s.ReplicaPolicy = ReplicaPolicyLag
 	}
 
 	var err error
@@ -144,7 +292,7 @@ func (s *BgSave) Run() {
 	}
 
 	fmt.Println("\n📊 节点信息:")
-	s.printNodes(s.Masters, s.Slaves)
+	s.printNodes(s.Shards)
 
 	err = s.mkTmpDir()
 	if err != nil {
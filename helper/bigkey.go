@@ -5,121 +5,398 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/hdt3213/rdb/bytefmt"
-	"github.com/hdt3213/rdb/core"
 	"github.com/hdt3213/rdb/model"
 )
 
-func findIt(rdbFilename string, top *topList, outputFile *os.File, csvWriter *csv.Writer, closeOutput bool, options ...interface{}) error {
-	if rdbFilename == "" {
-		return errors.New("src file path is required")
+// 大KEY的排名方式
+const (
+	RankBySize   = "size"   // 按内存占用
+	RankByCount  = "count"  // 按元素个数，元素多的KEY对LRANGE、DEL等操作的延迟影响更大
+	RankByKeyLen = "keylen" // 按KEY名长度
+)
+
+// 大KEY的分组排名维度
+const (
+	RankGroupType = "type"
+	RankGroupDB   = "db"
+)
+
+// bigkeyRecord 排名中只保留KEY的统计信息，不持有KEY的值，排名的内存占用只与N有关
+// 开启元素明细时暂存排名中集合类型KEY的值，直到该数据源分析完成
+type bigkeyRecord struct {
+	db        int
+	key       string
+	typ       string
+	size      int
+	elemCount int
+	source    string
+	rank      int               // 排名依据的值，由排名方式决定
+	elements  *elementSummary   // 集合类型KEY的元素明细，未开启时为nil
+	object    model.RedisObject // 开启元素明细时暂存排名中的集合类型KEY，Finish时只为最终排名中的KEY统计元素
+}
+
+// GetSize 返回排名依据的值，topList按它排序
+func (r *bigkeyRecord) GetSize() int {
+	return r.rank
+}
+
+func groupOf(dimension string, typ string, db int) string {
+	if dimension == RankGroupDB {
+		return strconv.Itoa(db)
 	}
-	rdbFile, err := os.Open(rdbFilename)
-	if err != nil {
-		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
-	}
-	defer func() {
-		_ = rdbFile.Close()
-	}()
-	if closeOutput {
-		defer func() {
-			_ = outputFile.Close()
-		}()
-	}
-	defer csvWriter.Flush()
-	var dec decoder = core.NewDecoder(rdbFile)
-	if dec, err = wrapDecoder(dec, options...); err != nil {
-		return err
-	}
-	err = dec.Parse(func(object model.RedisObject) bool {
-		top.add(object)
+	return typ
+}
+
+// bigkeyRanking 总排名及按类型、DB分组的排名，每个排名只保留N个KEY
+type bigkeyRanking struct {
+	topN   int
+	all    *topList
+	groups map[string]map[string]*topList // 分组维度 -> 分组值 -> 排名
+}
+
+func newBigkeyRanking(topN int, dimensions []string) *bigkeyRanking {
+	r := &bigkeyRanking{topN: topN, all: newToplist(topN), groups: make(map[string]map[string]*topList)}
+	for _, dimension := range dimensions {
+		r.groups[dimension] = make(map[string]*topList)
+	}
+	return r
+}
+
+func (r *bigkeyRanking) groupList(dimension string, group string) *topList {
+	tl := r.groups[dimension][group]
+	if tl == nil {
+		tl = newToplist(r.topN)
+		r.groups[dimension][group] = tl
+	}
+	return tl
+}
+
+// accepts 判断KEY能否进入任一排名，都不能进入时不需要构造记录
+func (r *bigkeyRanking) accepts(rank int, typ string, db int) bool {
+	if r.all.accepts(rank) {
 		return true
-	})
-	if err != nil {
-		return err
-	}
-	// 与memory模式不同的是，大key扫描的结果，需要在最终关闭输出时，才输出内容到csv，而memory模式则总是输出到csv
-	if closeOutput {
-		for _, o := range top.list {
-			object := o.(model.RedisObject)
-			err = csvWriter.Write([]string{
-				strconv.Itoa(object.GetDBIndex()),
-				object.GetKey(),
-				object.GetType(),
-				strconv.Itoa(object.GetSize()),
-				bytefmt.FormatSize(uint64(object.GetSize())),
-				strconv.Itoa(object.GetElemCount()),
-			})
-			if err != nil {
-				return fmt.Errorf("csv write failed: %v", err)
+	}
+	for dimension := range r.groups {
+		if r.groupList(dimension, groupOf(dimension, typ, db)).accepts(rank) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *bigkeyRanking) add(record *bigkeyRecord) {
+	if r.all.accepts(record.rank) {
+		r.all.add(record)
+	}
+	for dimension := range r.groups {
+		tl := r.groupList(dimension, groupOf(dimension, record.typ, record.db))
+		if tl.accepts(record.rank) {
+			tl.add(record)
+		}
+	}
+}
+
+// merge 合并另一个数据源的排名，同一个KEY可能同时在总排名和分组排名中，只合并一次
+func (r *bigkeyRanking) merge(o *bigkeyRanking) {
+	seen := make(map[*bigkeyRecord]bool)
+	lists := []*topList{o.all}
+	for _, groups := range o.groups {
+		for _, tl := range groups {
+			lists = append(lists, tl)
+		}
+	}
+	for _, tl := range lists {
+		for _, x := range tl.list {
+			record := x.(*bigkeyRecord)
+			if !seen[record] {
+				seen[record] = true
+				r.add(record)
 			}
 		}
 	}
-	return nil
 }
 
-// FindBiggestKeys read rdb file and find the largest N keys.
-// The invoker owns output, FindBiggestKeys won't close it
-func FindBiggestKeys(rdbFiles []string, topN int, workDir string, workDirName string, options ...interface{}) error {
-	fmt.Println("🔍 启动大KEY分析任务")
-	fmt.Println("==========================================")
+// sortRecords 排名相同的KEY按数据源和KEY名排序，保证并行分析时结果稳定
+func sortRecords(tl *topList) {
+	sort.SliceStable(tl.list, func(i, j int) bool {
+		ri, rj := tl.list[i].(*bigkeyRecord), tl.list[j].(*bigkeyRecord)
+		if ri.rank != rj.rank {
+			return ri.rank > rj.rank
+		}
+		if ri.source != rj.source {
+			return ri.source < rj.source
+		}
+		return ri.key < rj.key
+	})
+}
+
+// bigkeyAnalyzer 输出每个RDB文件中最大的N个KEY，多个数据源时另外输出所有数据源合并后的TOP N
+// 指定分组维度时，另外输出每种类型、每个DB各自的TOP N
+type bigkeyAnalyzer struct {
+	cfg        *AnalyzeConfig
+	topN       int
+	rankBy     string
+	dimensions []string
+	elementTop int        // 每个集合类型KEY输出的最大元素数，0表示不输出元素明细
+	mu         sync.Mutex // 保护merged和sources，并行分析时各文件在Finish中合并
+	merged     *bigkeyRanking
+	sources    int
+}
 
-	if topN < 0 {
-		return errors.New("❌ 错误: 结果数量必须大于0")
-	} else if topN == 0 {
+func newBigkeyAnalyzer(cfg *AnalyzeConfig) (Analyzer, error) {
+	if cfg.TopN < 0 {
+		return nil, errors.New("结果数量必须大于0")
+	}
+	topN := cfg.TopN
+	if topN == 0 {
 		topN = 100
 	}
+	if cfg.ElementTopN < 0 {
+		return nil, errors.New("元素数量必须大于0")
+	}
+	rankBy := cfg.RankBy
+	switch rankBy {
+	case "":
+		rankBy = RankBySize
+	case RankBySize, RankByCount, RankByKeyLen:
+	default:
+		return nil, fmt.Errorf("不支持的排名方式: %s，可选值: size, count, keylen", rankBy)
+	}
+	for _, dimension := range cfg.RankGroups {
+		if dimension != RankGroupType && dimension != RankGroupDB {
+			return nil, fmt.Errorf("不支持的分组维度: %s，可选值: type, db", dimension)
+		}
+	}
+	return &bigkeyAnalyzer{
+		cfg:        cfg,
+		topN:       topN,
+		rankBy:     rankBy,
+		dimensions: cfg.RankGroups,
+		elementTop: cfg.ElementTopN,
+		merged:     newBigkeyRanking(topN, cfg.RankGroups),
+	}, nil
+}
 
-	fmt.Printf("📁 工作目录: %s\n", workDir)
-	fmt.Printf("📊 分析文件数量: %d\n", len(rdbFiles))
-	fmt.Printf("🎯 显示TOP %d 大KEY\n\n", topN)
-
-	var outputFiles []string // 用于收集生成的文件路径，后续压缩
+func (a *bigkeyAnalyzer) rankOf(object model.RedisObject) int {
+	switch a.rankBy {
+	case RankByCount:
+		return object.GetElemCount()
+	case RankByKeyLen:
+		return len(object.GetKey())
+	}
+	return object.GetSize()
+}
 
-	for i, rdbFilename := range rdbFiles {
-		fmt.Printf("[%d/%d] 正在分析: %s\n", i+1, len(rdbFiles), rdbFilename)
+func (a *bigkeyAnalyzer) Begin(rdbFilename string) (FileAnalyzer, error) {
+	// 先创建文件占用文件名，分析完成后再写入
+	outputs, err := a.createOutputs(rdbFilename)
+	if err != nil {
+		return nil, err
+	}
+	return &bigkeyFileAnalyzer{
+		bigkeyAnalyzer: a,
+		source:         sourceLabel(rdbFilename),
+		outputs:        outputs,
+		ranking:        newBigkeyRanking(a.topN, a.dimensions),
+	}, nil
+}
 
-		outputPath, outputFile, err := createOutPath(rdbFilename, workDir, "-bigkey.csv", false)
+// createOutputs 创建总排名、各分组排名和元素明细的结果文件，如 dump-bigkey.csv, dump-bigkey-type.csv, dump-bigkey-elements.csv
+func (a *bigkeyAnalyzer) createOutputs(src string) ([]*os.File, error) {
+	var outputs []*os.File
+	suffixes := []string{"-bigkey.csv"}
+	for _, dimension := range a.dimensions {
+		suffixes = append(suffixes, "-bigkey-"+dimension+".csv")
+	}
+	if a.elementTop > 0 {
+		suffixes = append(suffixes, "-bigkey-elements.csv")
+	}
+	for _, suffix := range suffixes {
+		_, outputFile, err := a.cfg.CreateOutput(src, suffix)
 		if err != nil {
-			return fmt.Errorf("❌ 创建输出文件失败: %v", err)
+			for _, f := range outputs {
+				_ = f.Close()
+			}
+			return nil, fmt.Errorf("创建输出文件失败: %v", err)
 		}
+		outputs = append(outputs, outputFile)
+	}
+	return outputs, nil
+}
 
-		// 收集输出文件路径
-		outputFiles = append(outputFiles, outputPath)
+// Close 多个数据源时输出合并排名，如集群所有分片中最大的N个KEY
+func (a *bigkeyAnalyzer) Close() ([]string, error) {
+	if a.sources < 2 {
+		return nil, nil
+	}
+	outputs, err := a.createOutputs("all")
+	if err != nil {
+		return nil, err
+	}
+	return a.writeRanking(outputs, a.merged, true)
+}
 
-		// 写入CSV头部
-		_, err = outputFile.WriteString("database,key,type,size,size_readable,element_count\n")
-		if err != nil {
-			return fmt.Errorf("❌ 写入CSV头部失败: %v", err)
+// writeRanking 将总排名、各分组排名和元素明细写入对应的文件并关闭
+func (a *bigkeyAnalyzer) writeRanking(outputs []*os.File, ranking *bigkeyRanking, withSource bool) ([]string, error) {
+	var paths []string
+	var err error
+	for i, outputFile := range outputs {
+		paths = append(paths, outputFile.Name())
+		if err == nil {
+			if i == 0 {
+				sortRecords(ranking.all)
+				err = writeBigkeys(outputFile, "", []string{""}, map[string]*topList{"": ranking.all}, withSource)
+			} else if i > len(a.dimensions) {
+				err = writeElements(outputFile, ranking.all, withSource)
+			} else {
+				dimension := a.dimensions[i-1]
+				groups := ranking.groups[dimension]
+				names := make([]string, 0, len(groups))
+				for name, tl := range groups {
+					sortRecords(tl)
+					names = append(names, name)
+				}
+				sortGroupNames(names)
+				err = writeBigkeys(outputFile, dimension, names, groups, withSource)
+			}
 		}
+		_ = outputFile.Close()
+	}
+	return paths, err
+}
 
-		csvWriter := csv.NewWriter(outputFile)
-		top := newToplist(topN)
-		err = findIt(rdbFilename, top, outputFile, csvWriter, true, options...)
-		if err != nil {
-			return fmt.Errorf("❌ 分析RDB文件失败: %v", err)
+// sortGroupNames 分组按名称排序，DB按数字顺序
+func sortGroupNames(names []string) {
+	sort.Slice(names, func(i, j int) bool {
+		ni, erri := strconv.Atoi(names[i])
+		nj, errj := strconv.Atoi(names[j])
+		if erri == nil && errj == nil {
+			return ni < nj
 		}
+		return names[i] < names[j]
+	})
+}
 
-		fmt.Printf("  ✅ 完成 -> %s\n", outputPath)
+type bigkeyFileAnalyzer struct {
+	*bigkeyAnalyzer
+	source  string
+	outputs []*os.File
+	ranking *bigkeyRanking
+}
+
+func (fa *bigkeyFileAnalyzer) Add(object model.RedisObject) {
+	rank := fa.rankOf(object)
+	if !fa.ranking.accepts(rank, object.GetType(), object.GetDBIndex()) {
+		return
+	}
+	record := &bigkeyRecord{
+		db:        object.GetDBIndex(),
+		key:       object.GetKey(),
+		typ:       object.GetType(),
+		size:      object.GetSize(),
+		elemCount: object.GetElemCount(),
+		source:    fa.source,
+		rank:      rank,
+	}
+	if fa.elementTop > 0 && hasElements(object) {
+		// 被挤出排名的KEY不再被引用，值随之释放
+		record.object = object
 	}
+	fa.ranking.add(record)
+}
 
-	fmt.Println("\n📦 正在打包报告文件...")
-	// 压缩输出文件
-	if len(outputFiles) > 0 {
-		zipPath := generateZipName(workDir, workDirName)
-		err := compressFiles(outputFiles, zipPath)
-		if err != nil {
-			fmt.Printf("❌ 压缩失败: %v\n", err)
-		} else {
-			fmt.Printf("✅ 压缩完成: %s\n", zipPath)
-			// 清理原始文件
-			cleanupFiles(outputFiles)
+// summarizeRanking 为最终排名中的KEY统计元素，统计后不再持有KEY的值
+func (fa *bigkeyFileAnalyzer) summarizeRanking() {
+	lists := []*topList{fa.ranking.all}
+	for _, groups := range fa.ranking.groups {
+		for _, tl := range groups {
+			lists = append(lists, tl)
 		}
 	}
+	for _, tl := range lists {
+		for _, x := range tl.list {
+			record := x.(*bigkeyRecord)
+			if record.object != nil {
+				record.elements = summarizeElements(record.object, fa.elementTop)
+				record.object = nil
+			}
+		}
+	}
+}
 
-	fmt.Println("==========================================")
-	fmt.Printf("🎉 大KEY分析任务完成，共分析 %d 个RDB文件\n", len(rdbFiles))
-	return nil
+// Finish 与memory不同，大key的结果在文件分析完成后才一次性写入csv
+func (fa *bigkeyFileAnalyzer) Finish() ([]string, error) {
+	fa.summarizeRanking()
+	fa.mu.Lock()
+	fa.sources++
+	fa.merged.merge(fa.ranking)
+	fa.mu.Unlock()
+	return fa.writeRanking(fa.outputs, fa.ranking, false)
+}
+
+// writeBigkeys 将排名写入csv，dimension不为空时第一列为分组，withSource为true时增加数据源列
+func writeBigkeys(outputFile *os.File, dimension string, groups []string, lists map[string]*topList, withSource bool) error {
+	header := "database,key,type,size,size_readable,element_count"
+	if dimension != "" {
+		header = dimension + "," + header
+	}
+	if withSource {
+		header += ",source"
+	}
+	_, err := outputFile.WriteString(header + "\n")
+	if err != nil {
+		return fmt.Errorf("写入CSV头部失败: %v", err)
+	}
+	csvWriter := csv.NewWriter(outputFile)
+	for _, group := range groups {
+		for _, o := range lists[group].list {
+			record := o.(*bigkeyRecord)
+			row := []string{
+				strconv.Itoa(record.db),
+				record.key,
+				record.typ,
+				strconv.Itoa(record.size),
+				bytefmt.FormatSize(uint64(record.size)),
+				strconv.Itoa(record.elemCount),
+			}
+			if dimension != "" {
+				row = append([]string{group}, row...)
+			}
+			if withSource {
+				row = append(row, record.source)
+			}
+			if err = csvWriter.Write(row); err != nil {
+				return fmt.Errorf("csv write failed: %v", err)
+			}
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// sourceLabel 结果中标识数据源：从Redis导出的RDB显示节点地址，其余显示文件路径
+func sourceLabel(src string) string {
+	base := filepath.Base(src)
+	if strings.HasPrefix(base, dumpFilePrefix) && strings.HasSuffix(base, ".rdb") {
+		node := strings.TrimSuffix(strings.TrimPrefix(base, dumpFilePrefix), ".rdb")
+		if i := strings.LastIndex(node, "-"); i > 0 {
+			return node[:i] + ":" + node[i+1:]
+		}
+	}
+	if src == stdinSrc {
+		return "stdin"
+	}
+	return src
+}
+
+// FindBiggestKeys read rdb file and find the largest N keys.
+func FindBiggestKeys(rdbFiles []string, topN int, workDir string, workDirName string, options ...interface{}) error {
+	return Analyse("bigkey", rdbFiles, AnalyzeConfig{WorkDir: workDir, WorkDirName: workDirName, TopN: topN}, options...)
 }
@@ -2,42 +2,82 @@ package helper
 
 import (
 	"archive/zip"
-	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// TLSOptions TLS连接配置，数据源为 rediss:// 时生效
+type TLSOptions struct {
+	CACert     string // CA证书文件，为空时使用系统证书
+	ClientCert string // 客户端证书文件，双向认证时使用
+	ClientKey  string // 客户端私钥文件
+	SkipVerify bool   // 跳过服务端证书校验
+}
+
 // RedisConnection Redis连接配置和信息
 type RedisConnection struct {
-	RedisServer string
-	Password    string
-	NoCluster   bool
-	HostPort    string
-	DB          int
-	Info        map[string]string
-	IsCluster   bool
-	Masters     []string
-	Slaves      []string
+	RedisServer      string
+	Username         string
+	Password         string
+	NoCluster        bool
+	TLS              TLSOptions
+	tlsConfig        *tls.Config
+	SentinelUsername string // 哨兵的ACL用户名，与数据节点分开配置
+	SentinelPassword string // 哨兵的密码，与数据节点密码分开配置
+	HostPort         string
+	DB               int
+	Info             map[string]string
+	IsCluster        bool
+	Masters          []string
+	Slaves           []string
+	Shards           []*Shard
 }
 
 // ConnectRedis 连接Redis并识别模式
 func (rc *RedisConnection) ConnectRedis() error {
-	rc.HostPort, rc.DB = parseSrc(rc.RedisServer)
+	var sentinelShard *Shard
+	if IsSentinelSrc(rc.RedisServer) {
+		var err error
+		sentinelShard, err = rc.resolveSentinel()
+		if err != nil {
+			return err
+		}
+	} else {
+		src, err := parseSrc(rc.RedisServer)
+		if err != nil {
+			return err
+		}
+		rc.HostPort, rc.DB = src.hostPort, src.db
+		// 命令行指定的用户名密码优先于连接地址中的
+		if rc.Username == "" {
+			rc.Username = src.username
+		}
+		if rc.Password == "" {
+			rc.Password = src.password
+		}
+		if src.useTLS {
+			rc.tlsConfig, err = rc.TLS.config()
+			if err != nil {
+				return err
+			}
+		}
+	}
 
 	fmt.Printf("「连接」- 以单机模式连接至Redis：%s...\n", rc.HostPort)
 	ctx := context.Background()
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     rc.HostPort,
-		Password: rc.Password,
-		DB:       rc.DB,
-	})
+	redisClient := rc.CreateRedisClient()
 	defer func(redisClient *redis.Client) {
 		err := redisClient.Close()
 		if err != nil {
@@ -56,31 +96,20 @@ func (rc *RedisConnection) ConnectRedis() error {
 	}
 
 	// 解析Redis信息
-	scanner := bufio.NewScanner(strings.NewReader(infoStr))
-	info := make(map[string]string)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, ":") {
-			lineArr := strings.Split(line, ":")
-			info[lineArr[0]] = lineArr[1]
-		}
-	}
+	info := parseInfo(infoStr)
 	rc.Info = info
 
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("error occurred: %v\n", err)
-	}
-
 	// 识别Redis模式和节点
-	var masters []string
-	var slaves []string
-
 	if info["redis_mode"] == "standalone" {
 		fmt.Println("「连接」- 检测到Redis为 单机/哨兵模式...")
-		if info["role"] == "master" {
-			masters = append(masters, rc.HostPort)
+		if sentinelShard != nil {
+			// 哨兵模式以哨兵看到的拓扑为准
+			if info["role"] != "master" {
+				fmt.Printf("「连接」- ⚠️  哨兵返回的主节点 %s 当前角色为 %s\n", rc.HostPort, info["role"])
+			}
+			rc.Shards = []*Shard{sentinelShard}
 		} else {
-			slaves = append(slaves, rc.HostPort)
+			rc.Shards = []*Shard{standaloneShard(rc.HostPort, info)}
 		}
 		rc.IsCluster = false
 	} else if info["redis_mode"] == "cluster" {
@@ -89,17 +118,10 @@ func (rc *RedisConnection) ConnectRedis() error {
 
 		if rc.NoCluster {
 			fmt.Println("「连接」- 用户指定不使用集群模式...")
-			if info["role"] == "master" {
-				masters = append(masters, rc.HostPort)
-			} else {
-				slaves = append(slaves, rc.HostPort)
-			}
+			rc.Shards = []*Shard{standaloneShard(rc.HostPort, info)}
 		} else {
 			fmt.Println("「连接」- 以集群模式重连Redis...")
-			clusterClient := redis.NewClusterClient(&redis.ClusterOptions{
-				Addrs:    []string{rc.HostPort},
-				Password: rc.Password,
-			})
+			clusterClient := rc.CreateRedisClusterClient()
 			defer func(redisClient *redis.ClusterClient) {
 				err := redisClient.Close()
 				if err != nil {
@@ -112,45 +134,70 @@ func (rc *RedisConnection) ConnectRedis() error {
 				fmt.Printf("「连接」- 获取集群节点异常: %v\n", err)
 				return err
 			}
-
-			scanner := bufio.NewScanner(strings.NewReader(clusterNodesStr))
-			for scanner.Scan() {
-				line := scanner.Text()
-				if strings.Contains(line, "@") {
-					lineArr := strings.Split(line, " ")
-					addrStr := lineArr[1]
-					roleStr := lineArr[2]
-					addrArr := strings.Split(addrStr, "@")
-					addr := addrArr[0]
-					if strings.Contains(roleStr, "master") {
-						masters = append(masters, addr)
-					} else if strings.Contains(roleStr, "slave") {
-						slaves = append(slaves, addr)
-					}
-				}
-			}
+			rc.Shards = parseClusterNodes(clusterNodesStr)
 		}
 	}
 
+	rc.nameShards()
+
+	var masters []string
+	var slaves []string
+	for _, shard := range rc.Shards {
+		masters = append(masters, shard.Master.Addr)
+		for _, replica := range shard.Replicas {
+			slaves = append(slaves, replica.Addr)
+		}
+	}
 	rc.Masters = masters
 	rc.Slaves = slaves
 	return nil
 }
 
+// nameShards 为分片设置稳定标识: 集群为连接地址加slot范围，哨兵为主节点名称，单机为连接地址
+// 主从切换或选择不同的从节点导出时标识不变，同一分片的趋势数据不会被拆分
+func (rc *RedisConnection) nameShards() {
+	for _, shard := range rc.Shards {
+		switch {
+		case shard.Name != "":
+		case shard.Slots != "":
+			shard.Name = rc.HostPort + "/" + shard.Slots
+		case rc.IsCluster && len(rc.Shards) > 1:
+			// 没有分配slot的主节点只能使用节点地址
+			shard.Name = shard.Master.Addr
+		default:
+			shard.Name = rc.HostPort
+		}
+	}
+}
+
 // CreateRedisClient 创建Redis客户端（单机模式）
 func (rc *RedisConnection) CreateRedisClient() *redis.Client {
 	return redis.NewClient(&redis.Options{
-		Addr:     rc.HostPort,
-		Password: rc.Password,
-		DB:       rc.DB,
+		Addr:      rc.HostPort,
+		Username:  rc.Username,
+		Password:  rc.Password,
+		DB:        rc.DB,
+		TLSConfig: rc.tlsConfig,
+	})
+}
+
+// createNodeClient 创建连接到指定节点的客户端
+func (rc *RedisConnection) createNodeClient(addr string) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:      addr,
+		Username:  rc.Username,
+		Password:  rc.Password,
+		TLSConfig: rc.tlsConfig,
 	})
 }
 
 // CreateRedisClusterClient 创建Redis集群客户端
 func (rc *RedisConnection) CreateRedisClusterClient() *redis.ClusterClient {
 	return redis.NewClusterClient(&redis.ClusterOptions{
-		Addrs:    []string{rc.HostPort},
-		Password: rc.Password,
+		Addrs:     []string{rc.HostPort},
+		Username:  rc.Username,
+		Password:  rc.Password,
+		TLSConfig: rc.tlsConfig,
 	})
 }
 
@@ -159,12 +206,32 @@ func getOutPath(rdbFilename string, workDir string, suffix string) (string, erro
 	return outputPath, err
 }
 
+// outPathLock 并行分析时，同名数据源的结果文件名需要串行分配
+var outPathLock sync.Mutex
+
 func createOutPath(rdbFilename string, workDir string, suffix string, dryRun bool) (string, *os.File, error) {
+	outPathLock.Lock()
+	defer outPathLock.Unlock()
 	// 生成基于RDB文件名的输出文件路径
 	baseName := filepath.Base(rdbFilename)
-	// 移除.rdb扩展名
-	baseName = strings.TrimSuffix(baseName, ".rdb")
+	if rdbFilename == stdinSrc {
+		baseName = "stdin"
+	}
+	// 移除压缩扩展名和.rdb/.aof/.manifest扩展名
+	for _, ext := range []string{".gz", ".zst", ".lz4"} {
+		baseName = strings.TrimSuffix(baseName, ext)
+	}
+	for _, ext := range []string{".rdb", ".aof", manifestSuffix} {
+		baseName = strings.TrimSuffix(baseName, ext)
+	}
 	outputPath := fmt.Sprintf("%s/%s%s", workDir, baseName, suffix)
+	// 不同目录下的同名RDB(如按日期归档的dump.rdb)，追加序号避免覆盖
+	for i := 1; ; i++ {
+		if _, err := os.Stat(outputPath); os.IsNotExist(err) {
+			break
+		}
+		outputPath = fmt.Sprintf("%s/%s-%d%s", workDir, baseName, i, suffix)
+	}
 
 	if !dryRun {
 		outputFile, err := os.Create(outputPath)
@@ -176,16 +243,83 @@ func createOutPath(rdbFilename string, workDir string, suffix string, dryRun boo
 	return outputPath, nil, nil
 }
 
-func parseSrc(redisServer string) (hostPort string, dbInt int) {
-	hostPort = strings.Split(redisServer, "redis://")[1]
-	dbInt = 0
-	if strings.Contains(hostPort, "/") {
-		dbStr := strings.Split(hostPort, "/")[1]
-		if dbStr != "" {
-			dbInt, _ = strconv.Atoi(dbStr)
+// redisSrc 解析后的Redis连接地址
+type redisSrc struct {
+	hostPort string
+	db       int
+	username string
+	password string
+	useTLS   bool
+}
+
+// parseSrc 解析 redis://[user:pass@]host:port[/db] 或 rediss://... 形式的连接地址
+func parseSrc(redisServer string) (redisSrc, error) {
+	var src redisSrc
+	u, err := url.Parse(redisServer)
+	if err != nil {
+		return src, fmt.Errorf("invalid redis address %s: %v", redisServer, err)
+	}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		src.useTLS = true
+	default:
+		return src, fmt.Errorf("unsupported redis scheme: %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return src, fmt.Errorf("invalid redis address %s: host is required", redisServer)
+	}
+	src.hostPort = u.Host
+	if u.Port() == "" {
+		src.hostPort = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		src.username = u.User.Username()
+		src.password, _ = u.User.Password()
+		// redis://:pass@host 形式只有密码；redis://pass@host 也按密码处理，与redis-cli -u一致
+		if _, ok := u.User.Password(); !ok {
+			src.password, src.username = src.username, ""
+		}
+	}
+	if dbStr := strings.Trim(u.Path, "/"); dbStr != "" {
+		src.db, err = strconv.Atoi(dbStr)
+		if err != nil {
+			return src, fmt.Errorf("invalid redis db %s: %v", dbStr, err)
+		}
+	}
+	return src, nil
+}
+
+// IsRedisSrc 判断数据源是否为Redis连接地址
+func IsRedisSrc(src string) bool {
+	return strings.HasPrefix(src, "redis://") || strings.HasPrefix(src, "rediss://") || IsSentinelSrc(src)
+}
+
+// config 根据TLS选项生成tls.Config，ServerName留空由各节点地址推断
+func (o TLSOptions) config() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: o.SkipVerify,
+	}
+	if o.CACert != "" {
+		caPem, err := os.ReadFile(o.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("读取CA证书失败: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPem) {
+			return nil, fmt.Errorf("CA证书 %s 格式错误", o.CACert)
+		}
+		config.RootCAs = pool
+	}
+	if o.ClientCert != "" || o.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败: %v", err)
 		}
+		config.Certificates = []tls.Certificate{cert}
 	}
-	return hostPort, dbInt
+	return config, nil
 }
 
 // compressFiles 将文件列表压缩为ZIP文件
@@ -1,12 +1,16 @@
 package helper
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/bytedance/sonic"
-	"github.com/hdt3213/rdb/core"
 	"github.com/hdt3213/rdb/model"
 )
 
@@ -17,9 +21,9 @@ func jsonIt(rdbFilename string, outputFile *os.File, closeOutput bool, options .
 		return false, errors.New("src file path is required")
 	}
 	// open file
-	rdbFile, err := os.Open(rdbFilename)
+	dec, rdbFile, err := openDecoder(rdbFilename)
 	if err != nil {
-		return false, fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+		return false, err
 	}
 	defer func() {
 		_ = rdbFile.Close()
@@ -30,7 +34,6 @@ func jsonIt(rdbFilename string, outputFile *os.File, closeOutput bool, options .
 		}()
 	}
 	// create decoder
-	var dec decoder = core.NewDecoder(rdbFile)
 	if dec, err = wrapDecoder(dec, options...); err != nil {
 		return false, err
 	}
@@ -126,6 +129,75 @@ func ToJsons(rdbFiles []string, workDir string, workDirName string, options ...i
 	return nil
 }
 
+// aofStats AOF转换的统计，skipped记录无法转换的模块类型及KEY数
+type aofStats struct {
+	keys    int
+	cmds    int
+	skipped map[string]int
+}
+
+// add 累加另一个文件的统计
+func (s *aofStats) add(o aofStats) {
+	s.keys += o.keys
+	s.cmds += o.cmds
+	for moduleType, count := range o.skipped {
+		s.skipped[moduleType] += count
+	}
+}
+
+// skippedSummary 按模块类型输出跳过的KEY数，如 ReJSON-RL(12), MBbloom--(3)
+func (s *aofStats) skippedSummary() string {
+	var items []string
+	for moduleType, count := range s.skipped {
+		items = append(items, fmt.Sprintf("%s(%d)", moduleType, count))
+	}
+	sort.Strings(items)
+	return strings.Join(items, ", ")
+}
+
+// aofIt 将RDB中的对象转换为RESP命令写入output，按RDB中的顺序输出，DB切换时写入SELECT
+// 模块类型解析后没有原始数据，无法还原，只计入跳过的统计
+func aofIt(rdbFilename string, output io.Writer, options ...interface{}) (aofStats, error) {
+	stats := aofStats{skipped: make(map[string]int)}
+	dec, rdbFile, err := openDecoder(rdbFilename)
+	if err != nil {
+		return stats, err
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	if dec, err = wrapDecoder(dec, options...); err != nil {
+		return stats, err
+	}
+	db := 0
+	var writeErr error
+	err = dec.Parse(func(object model.RedisObject) bool {
+		cmdLines := ObjectToCmd(object, options...)
+		if len(cmdLines) == 0 {
+			if _, ok := object.(*model.ModuleTypeObject); ok {
+				stats.skipped[object.GetType()]++
+			}
+			return true
+		}
+		if object.GetDBIndex() != db {
+			db = object.GetDBIndex()
+			cmdLines = append([]CmdLine{{selectCmd, []byte(strconv.Itoa(db))}}, cmdLines...)
+		}
+		if _, writeErr = output.Write(CmdLinesToResp(cmdLines)); writeErr != nil {
+			return false
+		}
+		stats.keys++
+		stats.cmds += len(cmdLines)
+		return true
+	})
+	if err == nil {
+		err = writeErr
+	}
+	return stats, err
+}
+
+var selectCmd = []byte("SELECT")
+
 // ToAOF read rdb file and convert to aof file (Redis Serialization )
 func ToAOF(rdbFilename string, aofFilename string, options ...interface{}) error {
 	if rdbFilename == "" {
@@ -134,33 +206,79 @@ func ToAOF(rdbFilename string, aofFilename string, options ...interface{}) error
 	if aofFilename == "" {
 		return errors.New("output file path is required")
 	}
-	rdbFile, err := os.Open(rdbFilename)
-	if err != nil {
-		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
-	}
-	defer func() {
-		_ = rdbFile.Close()
-	}()
 	aofFile, err := os.Create(aofFilename)
 	if err != nil {
-		return fmt.Errorf("create json %s failed, %v", aofFilename, err)
+		return fmt.Errorf("create aof %s failed, %v", aofFilename, err)
 	}
 	defer func() {
 		_ = aofFile.Close()
 	}()
-
-	var dec decoder = core.NewDecoder(rdbFile)
-	if dec, err = wrapDecoder(dec, options...); err != nil {
+	writer := bufio.NewWriter(aofFile)
+	if _, err = aofIt(rdbFilename, writer, options...); err != nil {
 		return err
 	}
-	return dec.Parse(func(object model.RedisObject) bool {
-		cmdLines := ObjectToCmd(object, options...)
-		data := CmdLinesToResp(cmdLines)
-		_, err = aofFile.Write(data)
+	return writer.Flush()
+}
+
+// ToAOFs read rdb files and convert each to an aof file, hash fields are written in lex order
+// so that the same rdb always produces the same aof
+func ToAOFs(rdbFiles []string, workDir string, workDirName string, options ...interface{}) error {
+	fmt.Println("🔄 启动AOF转换任务")
+	fmt.Println("==========================================")
+
+	var outputFiles []string // 用于收集生成的文件路径，后续压缩
+
+	fmt.Printf("📁 工作目录: %s\n", workDir)
+	fmt.Printf("📊 转换文件数量: %d\n\n", len(rdbFiles))
+
+	options = append(options, lexOrder{})
+	total := aofStats{skipped: make(map[string]int)}
+	for i, rdbFilename := range rdbFiles {
+		fmt.Printf("[%d/%d] 正在转换: %s\n", i+1, len(rdbFiles), rdbFilename)
+
+		outputPath, outputFile, err := createOutPath(rdbFilename, workDir, ".aof", false)
 		if err != nil {
-			fmt.Printf("write failed: %v", err)
-			return true
+			return fmt.Errorf("❌ 创建输出文件失败: %v", err)
 		}
-		return true
-	})
+
+		// 收集输出文件路径
+		outputFiles = append(outputFiles, outputPath)
+
+		writer := bufio.NewWriter(outputFile)
+		stats, err := aofIt(rdbFilename, writer, options...)
+		if err == nil {
+			err = writer.Flush()
+		}
+		_ = outputFile.Close()
+		if err != nil {
+			return fmt.Errorf("❌ AOF转换失败: %v", err)
+		}
+
+		total.add(stats)
+		if len(stats.skipped) > 0 {
+			fmt.Printf("  ⚠️  模块类型无法转换，已跳过: %s\n", stats.skippedSummary())
+		}
+		fmt.Printf("  ✅ 完成 -> %s (KEY: %d, 命令: %d)\n", outputPath, stats.keys, stats.cmds)
+	}
+
+	fmt.Println("\n📦 正在打包AOF文件...")
+	// 压缩输出文件
+	if len(outputFiles) > 0 {
+		zipPath := generateZipName(workDir, workDirName)
+		err := compressFiles(outputFiles, zipPath)
+		if err != nil {
+			fmt.Printf("❌ 压缩失败: %v\n", err)
+		} else {
+			fmt.Printf("✅ 压缩完成: %s\n", zipPath)
+			// 清理原始文件
+			cleanupFiles(outputFiles)
+		}
+	}
+
+	fmt.Println("==========================================")
+	if len(total.skipped) > 0 {
+		fmt.Printf("⚠️  共跳过模块类型KEY: %s\n", total.skippedSummary())
+	}
+	fmt.Printf("🎉 AOF转换任务完成，共转换 %d 个RDB文件，%d 个KEY\n", len(rdbFiles), total.keys)
+	return nil
 }
@@ -11,11 +11,15 @@ import (
 )
 
 type DeleteTask struct {
-	RedisServer string
-	Password    string
-	Pattern     string
-	BatchSize   int
-	NoCluster   bool
+	RedisServer      string
+	Username         string
+	Password         string
+	Pattern          string
+	BatchSize        int
+	NoCluster        bool
+	TLS              TLSOptions
+	SentinelUsername string
+	SentinelPassword string
 	*RedisConnection
 }
 
@@ -221,9 +225,13 @@ func (d *DeleteTask) deleteBatchCluster(ctx context.Context, client *redis.Clust
 func (d *DeleteTask) Run() {
 	// 初始化Redis连接配置
 	d.RedisConnection = &RedisConnection{
-		RedisServer: d.RedisServer,
-		Password:    d.Password,
-		NoCluster:   d.NoCluster,
+		RedisServer:      d.RedisServer,
+		Username:         d.Username,
+		Password:         d.Password,
+		NoCluster:        d.NoCluster,
+		TLS:              d.TLS,
+		SentinelUsername: d.SentinelUsername,
+		SentinelPassword: d.SentinelPassword,
 	}
 
 	// 设置默认批次大小
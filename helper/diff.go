@@ -0,0 +1,488 @@
+package helper
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hdt3213/rdb/bytefmt"
+	"github.com/hdt3213/rdb/model"
+)
+
+// diffPartitions 对比时KEY按哈希分到多个临时文件，每次只把旧快照的一个分区加载到内存，
+// 内存占用约为 KEY数/diffPartitions 条记录
+var diffPartitions = 128
+
+// diffRecord 对比只需要KEY的元数据，不保存值
+type diffRecord struct {
+	db        int
+	key       string
+	typ       string
+	size      int
+	elemCount int
+	expireAt  int64 // 毫秒时间戳，0表示永久
+}
+
+func newDiffRecord(object model.RedisObject) *diffRecord {
+	r := &diffRecord{
+		db:        object.GetDBIndex(),
+		key:       object.GetKey(),
+		typ:       object.GetType(),
+		size:      object.GetSize(),
+		elemCount: object.GetElemCount(),
+	}
+	if expiration := object.GetExpiration(); expiration != nil {
+		r.expireAt = expiration.UnixMilli()
+	}
+	return r
+}
+
+func (r *diffRecord) encode(buf []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(r.db))
+	buf = binary.AppendUvarint(buf, uint64(len(r.key)))
+	buf = append(buf, r.key...)
+	buf = binary.AppendUvarint(buf, uint64(len(r.typ)))
+	buf = append(buf, r.typ...)
+	buf = binary.AppendUvarint(buf, uint64(r.size))
+	buf = binary.AppendUvarint(buf, uint64(r.elemCount))
+	return binary.AppendVarint(buf, r.expireAt)
+}
+
+func readDiffString(reader *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err = io.ReadFull(reader, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// readDiffRecord 读取一条记录，文件结束时返回io.EOF
+func readDiffRecord(reader *bufio.Reader) (*diffRecord, error) {
+	db, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	r := &diffRecord{db: int(db)}
+	if r.key, err = readDiffString(reader); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if r.typ, err = readDiffString(reader); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	size, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	elemCount, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if r.expireAt, err = binary.ReadVarint(reader); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	r.size, r.elemCount = int(size), int(elemCount)
+	return r, nil
+}
+
+func (r *diffRecord) expiration() string {
+	if r.expireAt == 0 {
+		return "PERSISTENT"
+	}
+	return time.UnixMilli(r.expireAt).Format(time.RFC3339)
+}
+
+// changes 返回发生变化的属性，如 type,size
+func (r *diffRecord) changes(o *diffRecord) []string {
+	var changes []string
+	if r.typ != o.typ {
+		changes = append(changes, "type")
+	}
+	if r.size != o.size {
+		changes = append(changes, "size")
+	}
+	if r.elemCount != o.elemCount {
+		changes = append(changes, "count")
+	}
+	if r.expireAt != o.expireAt {
+		changes = append(changes, "ttl")
+	}
+	return changes
+}
+
+// diffPrefix 前缀的增长汇总
+type diffPrefix struct {
+	db                      int
+	prefix                  string
+	oldCount, newCount      int
+	oldSize, newSize        int
+	added, removed, changed int
+}
+
+func (p *diffPrefix) growth() int {
+	return p.newSize - p.oldSize
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// formatGrowth 带符号的可读大小，如 -1.5M
+func formatGrowth(n int) string {
+	if n < 0 {
+		return "-" + bytefmt.FormatSize(uint64(-n))
+	}
+	return bytefmt.FormatSize(uint64(n))
+}
+
+// snapshotDiff 两个快照的对比，旧快照和新快照分别写入按KEY哈希分区的临时文件，再逐个分区对比
+type snapshotDiff struct {
+	cfg        *AnalyzeConfig
+	tmpDir     string
+	separators []string
+	prefixes   map[string]*diffPrefix
+	added      int
+	removed    int
+	changed    int
+	growth     int
+}
+
+// spill 解析数据源，将每个KEY的记录写入对应分区的临时文件，返回KEY数
+func (d *snapshotDiff) spill(srcs []string, name string, options ...interface{}) (int, error) {
+	writers := make([]*bufio.Writer, diffPartitions)
+	files := make([]*os.File, diffPartitions)
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				_ = f.Close()
+			}
+		}
+	}()
+	for i := range files {
+		f, err := os.Create(filepath.Join(d.tmpDir, name+"-"+strconv.Itoa(i)))
+		if err != nil {
+			return 0, fmt.Errorf("创建临时文件失败: %v", err)
+		}
+		files[i] = f
+		writers[i] = bufio.NewWriter(f)
+	}
+	count := 0
+	var buf []byte
+	var writeErr error
+	for _, src := range srcs {
+		fmt.Printf("  📄 解析: %s\n", src)
+		dec, closer, err := openDecoder(src)
+		if err != nil {
+			return 0, err
+		}
+		if dec, err = wrapDecoder(dec, options...); err != nil {
+			_ = closer.Close()
+			return 0, err
+		}
+		err = dec.Parse(func(object model.RedisObject) bool {
+			count++
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(genKey(object.GetDBIndex(), object.GetKey())))
+			buf = newDiffRecord(object).encode(buf[:0])
+			_, writeErr = writers[h.Sum32()%uint32(diffPartitions)].Write(buf)
+			return writeErr == nil
+		})
+		_ = closer.Close()
+		if writeErr != nil {
+			return 0, fmt.Errorf("写入临时文件失败: %v", writeErr)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("解析 %s 失败: %v", src, err)
+		}
+	}
+	for _, w := range writers {
+		if err := w.Flush(); err != nil {
+			return 0, fmt.Errorf("写入临时文件失败: %v", err)
+		}
+	}
+	return count, nil
+}
+
+func (d *snapshotDiff) prefix(r *diffRecord) *diffPrefix {
+	p, _ := capGroup(d.prefixes, r.key[:nextBoundary(r.key, d.separators)], func(prefix string) string {
+		return genKey(r.db, prefix)
+	}, func(prefix string) *diffPrefix {
+		return &diffPrefix{db: r.db, prefix: prefix}
+	})
+	return p
+}
+
+func openPartition(path string) (*bufio.Reader, *os.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("打开临时文件失败: %v", err)
+	}
+	return bufio.NewReader(f), f, nil
+}
+
+// comparePartition 加载旧快照的一个分区，与新快照的同一分区逐条对比，变化写入csvWriter
+func (d *snapshotDiff) comparePartition(i int, csvWriter *csv.Writer) error {
+	reader, f, err := openPartition(filepath.Join(d.tmpDir, "old-"+strconv.Itoa(i)))
+	if err != nil {
+		return err
+	}
+	old := make(map[string]*diffRecord)
+	for {
+		r, err := readDiffRecord(reader)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			_ = f.Close()
+			return fmt.Errorf("读取临时文件失败: %v", err)
+		}
+		old[genKey(r.db, r.key)] = r
+		p := d.prefix(r)
+		p.oldCount++
+		p.oldSize += r.size
+	}
+	_ = f.Close()
+
+	reader, f, err = openPartition(filepath.Join(d.tmpDir, "new-"+strconv.Itoa(i)))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	for {
+		r, err := readDiffRecord(reader)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("读取临时文件失败: %v", err)
+		}
+		p := d.prefix(r)
+		p.newCount++
+		p.newSize += r.size
+		k := genKey(r.db, r.key)
+		o := old[k]
+		if o == nil {
+			d.added++
+			p.added++
+			d.growth += r.size
+			err = writeDiffRow(csvWriter, "新增", nil, r, nil)
+		} else {
+			delete(old, k)
+			changes := o.changes(r)
+			if len(changes) == 0 {
+				continue
+			}
+			d.changed++
+			p.changed++
+			d.growth += r.size - o.size
+			err = writeDiffRow(csvWriter, "修改", o, r, changes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// 剩下的是新快照中已不存在的KEY，排序保证结果稳定
+	removed := make([]*diffRecord, 0, len(old))
+	for _, r := range old {
+		removed = append(removed, r)
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		if removed[i].db != removed[j].db {
+			return removed[i].db < removed[j].db
+		}
+		return removed[i].key < removed[j].key
+	})
+	for _, r := range removed {
+		d.removed++
+		d.prefix(r).removed++
+		d.growth -= r.size
+		if err = writeDiffRow(csvWriter, "删除", r, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var diffHeader = "变化,数据库,KEY名,旧类型,新类型,旧大小,新大小,大小变化,旧元素个数,新元素个数,旧过期时间,新过期时间,变化项\n"
+
+// writeDiffRow 写入一个变化的KEY，新增时old为nil，删除时cur为nil
+func writeDiffRow(csvWriter *csv.Writer, kind string, old *diffRecord, cur *diffRecord, changes []string) error {
+	row := make([]string, 13)
+	row[0] = kind
+	delta := 0
+	if old != nil {
+		row[1], row[2] = strconv.Itoa(old.db), old.key
+		row[3], row[5], row[8], row[10] = old.typ, strconv.Itoa(old.size), strconv.Itoa(old.elemCount), old.expiration()
+		delta -= old.size
+	}
+	if cur != nil {
+		row[1], row[2] = strconv.Itoa(cur.db), cur.key
+		row[4], row[6], row[9], row[11] = cur.typ, strconv.Itoa(cur.size), strconv.Itoa(cur.elemCount), cur.expiration()
+		delta += cur.size
+	}
+	row[7] = strconv.Itoa(delta)
+	row[12] = strings.Join(changes, ",")
+	return csvWriter.Write(row)
+}
+
+// writePrefixes 按大小变化的绝对值从大到小输出前topN个前缀
+func (d *snapshotDiff) writePrefixes(outputFile *os.File, topN int) error {
+	list := make([]*diffPrefix, 0, len(d.prefixes))
+	for _, p := range d.prefixes {
+		if p.added+p.removed+p.changed > 0 {
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if gi, gj := absInt(list[i].growth()), absInt(list[j].growth()); gi != gj {
+			return gi > gj
+		}
+		if list[i].db != list[j].db {
+			return list[i].db < list[j].db
+		}
+		return list[i].prefix < list[j].prefix
+	})
+	if len(list) > topN {
+		list = list[:topN]
+	}
+	_, err := outputFile.WriteString("数据库,前缀,旧KEY个数,新KEY个数,KEY个数变化,旧大小,新大小,大小变化,大小变化[K/M/G],新增KEY,删除KEY,修改KEY\n")
+	if err != nil {
+		return fmt.Errorf("写入CSV头部失败: %v", err)
+	}
+	csvWriter := csv.NewWriter(outputFile)
+	for _, p := range list {
+		err = csvWriter.Write([]string{
+			strconv.Itoa(p.db),
+			p.prefix,
+			strconv.Itoa(p.oldCount),
+			strconv.Itoa(p.newCount),
+			strconv.Itoa(p.newCount - p.oldCount),
+			strconv.Itoa(p.oldSize),
+			strconv.Itoa(p.newSize),
+			strconv.Itoa(p.growth()),
+			formatGrowth(p.growth()),
+			strconv.Itoa(p.added),
+			strconv.Itoa(p.removed),
+			strconv.Itoa(p.changed),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// run 生成变化明细 diff.csv 和前缀汇总 diff-prefix.csv
+func (d *snapshotDiff) run(oldSrcs []string, newSrcs []string, options ...interface{}) ([]string, error) {
+	fmt.Println("[1/3] 解析旧快照")
+	oldCount, err := d.spill(oldSrcs, "old", options...)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("[2/3] 解析新快照")
+	newCount, err := d.spill(newSrcs, "new", options...)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("[3/3] 对比快照 (旧: %d 个KEY, 新: %d 个KEY)\n", oldCount, newCount)
+
+	diffPath, diffFile, err := d.cfg.CreateOutput("diff", ".csv")
+	if err != nil {
+		return nil, fmt.Errorf("创建输出文件失败: %v", err)
+	}
+	defer func() {
+		_ = diffFile.Close()
+	}()
+	outputFiles := []string{diffPath}
+	if _, err = diffFile.WriteString(diffHeader); err != nil {
+		return outputFiles, fmt.Errorf("写入CSV头部失败: %v", err)
+	}
+	csvWriter := csv.NewWriter(diffFile)
+	for i := 0; i < diffPartitions; i++ {
+		if err = d.comparePartition(i, csvWriter); err != nil {
+			return outputFiles, err
+		}
+	}
+	csvWriter.Flush()
+	if err = csvWriter.Error(); err != nil {
+		return outputFiles, err
+	}
+
+	prefixPath, prefixFile, err := d.cfg.CreateOutput("diff", "-prefix.csv")
+	if err != nil {
+		return outputFiles, fmt.Errorf("创建输出文件失败: %v", err)
+	}
+	defer func() {
+		_ = prefixFile.Close()
+	}()
+	outputFiles = append(outputFiles, prefixPath)
+	topN := d.cfg.TopN
+	if topN <= 0 {
+		topN = 100
+	}
+	return outputFiles, d.writePrefixes(prefixFile, topN)
+}
+
+// Diff 对比两个快照，报告新增、删除和类型、大小、元素个数、过期时间发生变化的KEY，以及每个前缀的增长
+// 快照可以是多个RDB/AOF文件，如集群每个分片一个RDB
+func Diff(oldSrcs []string, newSrcs []string, cfg AnalyzeConfig, options ...interface{}) error {
+	if len(oldSrcs) == 0 || len(newSrcs) == 0 {
+		return errors.New("old and new snapshots are required")
+	}
+	if cfg.TopN < 0 {
+		return errors.New("结果数量必须大于0")
+	}
+	separators := cfg.separators()
+	tmpDir, err := os.MkdirTemp(cfg.WorkDir, "diff-")
+	if err != nil {
+		return fmt.Errorf("创建临时目录失败: %v", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	fmt.Println("🔍 启动快照对比任务")
+	fmt.Println("==========================================")
+	fmt.Printf("📁 工作目录: %s\n", cfg.WorkDir)
+	fmt.Printf("📊 旧快照文件数量: %d, 新快照文件数量: %d\n\n", len(oldSrcs), len(newSrcs))
+
+	d := &snapshotDiff{cfg: &cfg, tmpDir: tmpDir, separators: separators, prefixes: make(map[string]*diffPrefix)}
+	outputFiles, err := d.run(oldSrcs, newSrcs, options...)
+	for _, file := range outputFiles {
+		fmt.Printf("  ✅ 完成 -> %s\n", file)
+	}
+	if err != nil {
+		return fmt.Errorf("❌ 对比快照失败: %v", err)
+	}
+
+	fmt.Println("\n📦 正在打包报告文件...")
+	zipPath := generateZipName(cfg.WorkDir, cfg.WorkDirName)
+	if err = compressFiles(outputFiles, zipPath); err != nil {
+		fmt.Printf("❌ 压缩失败: %v\n", err)
+	} else {
+		fmt.Printf("✅ 压缩完成: %s\n", zipPath)
+		cleanupFiles(outputFiles)
+	}
+
+	fmt.Println("==========================================")
+	fmt.Printf("🎉 快照对比完成，新增 %d 个KEY，删除 %d 个KEY，修改 %d 个KEY，大小变化 %s\n",
+		d.added, d.removed, d.changed, formatGrowth(d.growth))
+	return nil
+}
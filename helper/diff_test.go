@@ -0,0 +1,63 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	oldSrc := writeTestAof(t, dir, "old.aof",
+		[]string{"SET", "user:1", "tom"},
+		[]string{"SET", "user:2", "jerry"},
+		[]string{"RPUSH", "queue:jobs", "a"},
+		[]string{"SET", "tmp", "1"},
+		[]string{"SET", "same", "v"},
+	)
+	newSrc := writeTestAof(t, dir, "new.aof",
+		[]string{"SET", "user:1", "tom"},
+		[]string{"EXPIRE", "user:1", "100"},
+		[]string{"RPUSH", "queue:jobs", "a", "b", "c"},
+		[]string{"SET", "user:3", strings.Repeat("x", 100)},
+		[]string{"SET", "same", "v"},
+	)
+	workDir := filepath.Join(dir, "report")
+	_ = os.MkdirAll(workDir, os.ModePerm)
+	// 分区数小于KEY数，验证跨分区的对比
+	defer func(n int) { diffPartitions = n }(diffPartitions)
+	diffPartitions = 3
+	d := &snapshotDiff{
+		cfg:        &AnalyzeConfig{WorkDir: workDir},
+		tmpDir:     t.TempDir(),
+		separators: []string{":"},
+		prefixes:   make(map[string]*diffPrefix),
+	}
+	files, err := d.run([]string{oldSrc}, []string{newSrc})
+	if err != nil || len(files) != 2 {
+		t.Errorf("wrong output: %v, %v", files, err)
+		return
+	}
+	if d.added != 1 || d.removed != 2 || d.changed != 2 {
+		t.Errorf("wrong summary: added %d, removed %d, changed %d", d.added, d.removed, d.changed)
+	}
+	content, _ := os.ReadFile(files[0])
+	var rows []string
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n")[1:] {
+		fields := strings.Split(line, ",")
+		rows = append(rows, fields[0]+" "+fields[2]+" "+strings.Trim(strings.Join(fields[12:], ","), "\""))
+	}
+	slices.Sort(rows)
+	// 设置过期时间后KEY的内存占用也会增加
+	expect := []string{"修改 queue:jobs size,count", "修改 user:1 size,ttl", "删除 tmp ", "删除 user:2 ", "新增 user:3 "}
+	if !slices.Equal(rows, expect) {
+		t.Errorf("wrong changes: %q", rows)
+	}
+	content, _ = os.ReadFile(files[1])
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[1], "0,user:,2,2,0,") || !strings.HasSuffix(lines[1], ",1,1,1") {
+		t.Errorf("wrong prefix growth:\n%s", content)
+	}
+}
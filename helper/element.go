@@ -0,0 +1,182 @@
+package helper
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/hdt3213/rdb/bytefmt"
+	"github.com/hdt3213/rdb/model"
+)
+
+// outlierRatio 元素大小超过其余元素平均大小的倍数时视为异常元素
+var outlierRatio = 10
+
+// maxElementLen 结果中元素名称的最大长度，超过时截断
+const maxElementLen = 128
+
+// element 集合中的一个元素，size为元素占用的字节数(hash为field+value，zset为member+8字节score)
+type element struct {
+	name string
+	size int
+}
+
+func (e *element) GetSize() int {
+	return e.size
+}
+
+// elementSummary 大KEY的元素明细: 最大的N个元素、平均大小和异常元素个数
+type elementSummary struct {
+	count    int
+	total    int
+	outliers int
+	top      *topList
+}
+
+func (s *elementSummary) average() int {
+	if s.count == 0 {
+		return 0
+	}
+	return s.total / s.count
+}
+
+// isOutlier 元素大小超过其余元素平均大小的outlierRatio倍
+func (s *elementSummary) isOutlier(size int) bool {
+	if s.count < 2 {
+		return false
+	}
+	return size > outlierRatio*(s.total-size)/(s.count-1)
+}
+
+// addElement 按大小降序保留最大的N个元素，大小相同时按名称排序，结果不受hash、set的遍历顺序影响
+func (s *elementSummary) addElement(size int, name func() string) {
+	tl := s.top
+	if !tl.accepts(size) && (len(tl.list) == 0 || size < tl.list[len(tl.list)-1].GetSize()) {
+		return
+	}
+	e := &element{name: name(), size: size}
+	index := sort.Search(len(tl.list), func(i int) bool {
+		o := tl.list[i].(*element)
+		return o.size < e.size || o.size == e.size && o.name > e.name
+	})
+	if index >= tl.capacity {
+		return
+	}
+	tl.list = append(tl.list, e)
+	copy(tl.list[index+1:], tl.list[index:])
+	tl.list[index] = e
+	if len(tl.list) > tl.capacity {
+		tl.list = tl.list[:tl.capacity]
+	}
+}
+
+// hasElements 判断KEY是否支持元素明细
+func hasElements(object model.RedisObject) bool {
+	switch object.(type) {
+	case *model.HashObject, *model.SetObject, *model.ZSetObject, *model.ListObject:
+		return true
+	}
+	return false
+}
+
+// forEachElement 遍历hash、set、zset、list的元素大小，name只在需要时调用，避免为每个元素构造名称
+// 其他类型不支持元素明细，返回false
+func forEachElement(object model.RedisObject, fn func(size int, name func() string)) bool {
+	switch o := object.(type) {
+	case *model.HashObject:
+		for field, value := range o.Hash {
+			fn(len(field)+len(value), func() string { return truncateElement(field) })
+		}
+	case *model.SetObject:
+		for _, member := range o.Members {
+			fn(len(member), func() string { return truncateElement(string(member)) })
+		}
+	case *model.ZSetObject:
+		for _, entry := range o.Entries {
+			fn(len(entry.Member)+8, func() string { return truncateElement(entry.Member) })
+		}
+	case *model.ListObject:
+		for i, value := range o.Values {
+			fn(len(value), func() string { return "[" + strconv.Itoa(i) + "] " + truncateElement(string(value)) })
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// summarizeElements 统计集合类型KEY的元素，返回nil表示该类型不支持
+// 需要遍历两次，第一次计算平均大小，第二次统计异常元素和最大的topN个元素
+func summarizeElements(object model.RedisObject, topN int) *elementSummary {
+	s := &elementSummary{top: newToplist(topN)}
+	if !forEachElement(object, func(size int, _ func() string) {
+		s.count++
+		s.total += size
+	}) {
+		return nil
+	}
+	forEachElement(object, func(size int, name func() string) {
+		if s.isOutlier(size) {
+			s.outliers++
+		}
+		s.addElement(size, name)
+	})
+	return s
+}
+
+func truncateElement(name string) string {
+	if len(name) <= maxElementLen {
+		return name
+	}
+	return name[:maxElementLen] + "..."
+}
+
+// writeElements 输出排名中每个集合类型KEY的最大元素，作为大KEY报告的明细
+func writeElements(outputFile *os.File, tl *topList, withSource bool) error {
+	header := "database,key,type,size,element_count,avg_element_size,outlier_count,rank,element,element_size,element_size_readable,outlier"
+	if withSource {
+		header += ",source"
+	}
+	if _, err := outputFile.WriteString(header + "\n"); err != nil {
+		return fmt.Errorf("写入CSV头部失败: %v", err)
+	}
+	csvWriter := csv.NewWriter(outputFile)
+	for _, o := range tl.list {
+		record := o.(*bigkeyRecord)
+		s := record.elements
+		if s == nil {
+			continue
+		}
+		for i, x := range s.top.list {
+			e := x.(*element)
+			outlier := "false"
+			if s.isOutlier(e.size) {
+				outlier = "true"
+			}
+			row := []string{
+				strconv.Itoa(record.db),
+				record.key,
+				record.typ,
+				strconv.Itoa(record.size),
+				strconv.Itoa(s.count),
+				strconv.Itoa(s.average()),
+				strconv.Itoa(s.outliers),
+				strconv.Itoa(i + 1),
+				e.name,
+				strconv.Itoa(e.size),
+				bytefmt.FormatSize(uint64(e.size)),
+				outlier,
+			}
+			if withSource {
+				row = append(row, record.source)
+			}
+			if err := csvWriter.Write(row); err != nil {
+				return fmt.Errorf("csv write failed: %v", err)
+			}
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
@@ -0,0 +1,72 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+func TestSummarizeElements(t *testing.T) {
+	hash := &model.HashObject{
+		BaseObject: &model.BaseObject{Key: "h"},
+		Hash: map[string][]byte{
+			"f1":  []byte("a"),
+			"f2":  []byte("b"),
+			"f3":  []byte("c"),
+			"big": []byte(strings.Repeat("x", 100)),
+		},
+	}
+	s := summarizeElements(hash, 2)
+	if s.count != 4 || s.total != 112 || s.average() != 28 || s.outliers != 1 {
+		t.Errorf("wrong summary: %+v", s)
+	}
+	if len(s.top.list) != 2 || s.top.list[0].(*element).name != "big" || s.top.list[0].GetSize() != 103 {
+		t.Errorf("wrong top elements: %v", s.top.list)
+	}
+	list := &model.ListObject{
+		BaseObject: &model.BaseObject{Key: "l"},
+		Values:     [][]byte{[]byte("a"), []byte(strings.Repeat("y", 200))},
+	}
+	if s := summarizeElements(list, 1); s.top.list[0].(*element).name != "[1] "+strings.Repeat("y", maxElementLen)+"..." {
+		t.Errorf("wrong list element: %q", s.top.list[0].(*element).name)
+	}
+	// 大小相同的元素按名称排序，不受hash遍历顺序影响
+	ties := &model.HashObject{BaseObject: &model.BaseObject{Key: "t"}, Hash: map[string][]byte{"c": []byte("1"), "a": []byte("1"), "b": []byte("1")}}
+	for i := 0; i < 10; i++ {
+		s := summarizeElements(ties, 2)
+		if len(s.top.list) != 2 || s.top.list[0].(*element).name != "a" || s.top.list[1].(*element).name != "b" {
+			t.Errorf("wrong tie order: %v", s.top.list)
+			break
+		}
+	}
+	if summarizeElements(&model.StringObject{BaseObject: &model.BaseObject{Key: "s"}}, 1) != nil {
+		t.Error("string should not have elements")
+	}
+}
+
+func TestBigkeyElements(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTestAof(t, dir, "node.aof",
+		[]string{"HSET", "user:1", "name", "tom", "avatar", strings.Repeat("x", 1000)},
+		[]string{"SET", "str", strings.Repeat("x", 2000)},
+		[]string{"ZADD", "rank", "1", "m1", "2", "m2"},
+	)
+	workDir := filepath.Join(dir, "report")
+	_ = os.MkdirAll(workDir, os.ModePerm)
+	cfg := AnalyzeConfig{WorkDir: workDir, WorkDirName: "report", ElementTopN: 1}
+	a, _ := newBigkeyAnalyzer(&cfg)
+	files, _, err := analyseFile(src, []Analyzer{a})
+	if err != nil || len(files) != 2 || filepath.Base(files[1]) != "node-bigkey-elements.csv" {
+		t.Errorf("wrong output: %v, %v", files, err)
+		return
+	}
+	content, _ := os.ReadFile(files[1])
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "0,user:1,hash,") || !strings.Contains(lines[1], ",2,506,1,1,avatar,1006,1006B,true") ||
+		!strings.HasPrefix(lines[2], "0,rank,zset,") {
+		t.Errorf("wrong elements:\n%s", content)
+	}
+}
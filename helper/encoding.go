@@ -0,0 +1,463 @@
+package helper
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hdt3213/rdb/bytefmt"
+	"github.com/hdt3213/rdb/model"
+)
+
+// nearMissRatio 元素个数和最大元素都不超过阈值的该倍数时，视为接近紧凑编码
+var nearMissRatio = 2
+
+// encodingDefaults 紧凑编码的阈值，与Redis 7.2的默认配置相同，可以通过 -param 覆盖
+// 旧版本的 *-ziplist-* 配置名同样可用
+var encodingDefaults = map[string]int{
+	"hash-max-listpack-entries": 128,
+	"hash-max-listpack-value":   64,
+	"zset-max-listpack-entries": 128,
+	"zset-max-listpack-value":   64,
+	"set-max-intset-entries":    512,
+	"set-max-listpack-entries":  128,
+	"set-max-listpack-value":    64,
+}
+
+// compactEncodings RDB中的紧凑编码，这些KEY不需要优化
+var compactEncodings = map[string]bool{
+	model.ZipMapEncoding:   true,
+	model.ZipListEncoding:  true,
+	model.ListPackEncoding: true,
+	model.IntSetEncoding:   true,
+}
+
+// encodingLimits 解析 -param 中的阈值配置
+func encodingLimits(params map[string]string) (map[string]int, error) {
+	limits := make(map[string]int, len(encodingDefaults))
+	for name, value := range encodingDefaults {
+		limits[name] = value
+	}
+	for name, value := range params {
+		name = strings.Replace(name, "ziplist", "listpack", 1)
+		if _, ok := encodingDefaults[name]; !ok {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("无效的编码阈值 %s=%s", name, value)
+		}
+		limits[name] = n
+	}
+	return limits, nil
+}
+
+// encodingAdvice 一个未使用紧凑编码但接近阈值的KEY
+type encodingAdvice struct {
+	db         int
+	key        string
+	typ        string
+	encoding   string
+	count      int
+	maxElement int
+	size       int
+	target     string // 目标编码: listpack 或 intset
+	reasons    []string
+	saved      int    // 转换为紧凑编码后预计节省的字节数
+	config     string // 需要调整的配置，如 hash-max-listpack-entries 150
+	reshape    string // 调整数据的建议
+	// 满足条件需要的阈值
+	needEntries, needValue int
+}
+
+func (a *encodingAdvice) GetSize() int {
+	return a.saved
+}
+
+// listpackEntrySize 估算listpack中一个元素的大小: 编码头 + 内容 + backlen
+func listpackEntrySize(n int) int {
+	switch {
+	case n < 64:
+		return n + 2
+	case n < 4096:
+		return n + 4
+	}
+	return n + 10
+}
+
+// intsetWidth 能容纳所有整数的intset编码宽度
+func intsetWidth(values []int64) int {
+	width := 2
+	for _, v := range values {
+		if v < -1<<31 || v > 1<<31-1 {
+			return 8
+		}
+		if v < -1<<15 || v > 1<<15-1 {
+			width = 4
+		}
+	}
+	return width
+}
+
+// adviseEncoding 检查hash、zset、set是否因为略超阈值而没有使用紧凑编码，不接近阈值或已经是紧凑编码时返回nil
+func adviseEncoding(object model.RedisObject, limits map[string]int) *encodingAdvice {
+	if compactEncodings[object.GetEncoding()] {
+		return nil
+	}
+	var prefix string
+	var sizes []int // 每个元素在listpack中的长度，hash的field和value各算一个
+	var integers []int64
+	count := 0
+	switch o := object.(type) {
+	case *model.HashObject:
+		prefix, count = "hash", len(o.Hash)
+		for field, value := range o.Hash {
+			sizes = append(sizes, len(field), len(value))
+		}
+	case *model.ZSetObject:
+		prefix, count = "zset", len(o.Entries)
+		for _, entry := range o.Entries {
+			sizes = append(sizes, len(entry.Member), 9) // score按整数或字符串存储，取一个近似值
+		}
+	case *model.SetObject:
+		prefix, count = "set", len(o.Members)
+		integers = make([]int64, 0, len(o.Members))
+		for _, member := range o.Members {
+			sizes = append(sizes, len(member))
+			if v, err := strconv.ParseInt(string(member), 10, 64); err == nil && strconv.FormatInt(v, 10) == string(member) {
+				integers = append(integers, v)
+			}
+		}
+	default:
+		return nil
+	}
+	if count == 0 {
+		return nil
+	}
+	maxElement := 0
+	for _, n := range sizes {
+		maxElement = max(maxElement, n)
+	}
+	advice := &encodingAdvice{
+		db:         object.GetDBIndex(),
+		key:        object.GetKey(),
+		typ:        object.GetType(),
+		encoding:   object.GetEncoding(),
+		count:      count,
+		maxElement: maxElement,
+		size:       object.GetSize(),
+		target:     model.ListPackEncoding,
+	}
+	compactSize := 0
+	if prefix == "set" && len(integers) == count {
+		// 全部是整数的set使用intset，没有元素大小的限制
+		entries := limits["set-max-intset-entries"]
+		if count <= entries || count > entries*nearMissRatio {
+			return nil
+		}
+		advice.target = model.IntSetEncoding
+		advice.maxElement = 0
+		advice.needEntries = count
+		advice.reasons = append(advice.reasons, fmt.Sprintf("元素个数 %d > %d", count, entries))
+		advice.config = fmt.Sprintf("set-max-intset-entries %d", count)
+		advice.reshape = fmt.Sprintf("拆分为 %d 个KEY", (count+entries-1)/entries)
+		compactSize = 8 + count*intsetWidth(integers)
+	} else {
+		entries, value := limits[prefix+"-max-listpack-entries"], limits[prefix+"-max-listpack-value"]
+		if count > entries*nearMissRatio || maxElement > value*nearMissRatio || count <= entries && maxElement <= value {
+			return nil
+		}
+		var configs, reshapes []string
+		if count > entries {
+			advice.needEntries = count
+			advice.reasons = append(advice.reasons, fmt.Sprintf("元素个数 %d > %d", count, entries))
+			configs = append(configs, fmt.Sprintf("%s-max-listpack-entries %d", prefix, count))
+			reshapes = append(reshapes, fmt.Sprintf("拆分为 %d 个KEY", (count+entries-1)/entries))
+		}
+		if maxElement > value {
+			oversize := 0
+			for _, n := range sizes {
+				if n > value {
+					oversize++
+				}
+			}
+			advice.needValue = maxElement
+			advice.reasons = append(advice.reasons, fmt.Sprintf("最大元素 %dB > %dB", maxElement, value))
+			configs = append(configs, fmt.Sprintf("%s-max-listpack-value %d", prefix, maxElement))
+			reshapes = append(reshapes, fmt.Sprintf("缩短或移出 %d 个超过 %dB 的元素", oversize, value))
+		}
+		advice.config = strings.Join(configs, "; ")
+		advice.reshape = strings.Join(reshapes, "; ")
+		compactSize = 7
+		for _, n := range sizes {
+			compactSize += listpackEntrySize(n)
+		}
+	}
+	advice.saved = max(advice.size-compactSize, 0)
+	return advice
+}
+
+// encodingPrefix 同一个前缀和类型中接近紧凑编码的KEY汇总
+type encodingPrefix struct {
+	db                     int
+	prefix                 string
+	typ                    string
+	keyCount               int
+	totalSize              int
+	saved                  int
+	needEntries, needValue int
+}
+
+// encodingStats 一个或多个数据源的编码建议，可以合并
+type encodingStats struct {
+	keys     *topList
+	prefixes map[string]*encodingPrefix // genKey(db, prefix) + " " + type -> 汇总
+}
+
+func newEncodingStats(topN int) *encodingStats {
+	return &encodingStats{keys: newToplist(topN), prefixes: make(map[string]*encodingPrefix)}
+}
+
+func (s *encodingStats) prefix(db int, prefix, typ string) *encodingPrefix {
+	p, _ := capGroup(s.prefixes, prefix, func(prefix string) string {
+		return genKey(db, prefix) + " " + typ
+	}, func(prefix string) *encodingPrefix {
+		return &encodingPrefix{db: db, prefix: prefix, typ: typ}
+	})
+	return p
+}
+
+func (p *encodingPrefix) add(keyCount, totalSize, saved, needEntries, needValue int) {
+	p.keyCount += keyCount
+	p.totalSize += totalSize
+	p.saved += saved
+	p.needEntries = max(p.needEntries, needEntries)
+	p.needValue = max(p.needValue, needValue)
+}
+
+func (s *encodingStats) merge(o *encodingStats) {
+	for _, x := range o.keys.list {
+		if s.keys.accepts(x.GetSize()) {
+			s.keys.add(x)
+		}
+	}
+	for _, src := range o.prefixes {
+		s.prefix(src.db, src.prefix, src.typ).add(src.keyCount, src.totalSize, src.saved, src.needEntries, src.needValue)
+	}
+}
+
+// encodingAnalyzer 编码优化建议: 找出因为略超listpack、intset阈值而使用hashtable、skiplist的KEY，
+// 估算调大配置或调整数据后节省的内存，并按前缀汇总
+// 多个数据源时另外输出所有数据源合并后的结果
+type encodingAnalyzer struct {
+	cfg        *AnalyzeConfig
+	topN       int
+	separators []string
+	limits     map[string]int
+	mu         sync.Mutex // 保护merged和sources
+	merged     *encodingStats
+	sources    int
+}
+
+func newEncodingAnalyzer(cfg *AnalyzeConfig) (Analyzer, error) {
+	if cfg.TopN < 0 {
+		return nil, errors.New("结果数量必须大于0")
+	}
+	topN := cfg.TopN
+	if topN == 0 {
+		topN = 100
+	}
+	separators := cfg.separators()
+	limits, err := encodingLimits(cfg.Params)
+	if err != nil {
+		return nil, err
+	}
+	return &encodingAnalyzer{
+		cfg:        cfg,
+		topN:       topN,
+		separators: separators,
+		limits:     limits,
+		merged:     newEncodingStats(topN),
+	}, nil
+}
+
+var encodingSuffixes = []string{"-encoding.csv", "-encoding-prefix.csv"}
+
+// createOutputs 创建KEY明细和前缀汇总两个结果文件
+func (a *encodingAnalyzer) createOutputs(src string) ([]*os.File, error) {
+	var outputs []*os.File
+	for _, suffix := range encodingSuffixes {
+		_, outputFile, err := a.cfg.CreateOutput(src, suffix)
+		if err != nil {
+			for _, f := range outputs {
+				_ = f.Close()
+			}
+			return nil, fmt.Errorf("创建输出文件失败: %v", err)
+		}
+		outputs = append(outputs, outputFile)
+	}
+	return outputs, nil
+}
+
+func (a *encodingAnalyzer) Begin(rdbFilename string) (FileAnalyzer, error) {
+	// 先创建文件占用文件名，分析完成后再写入
+	outputs, err := a.createOutputs(rdbFilename)
+	if err != nil {
+		return nil, err
+	}
+	return &encodingFileAnalyzer{
+		encodingAnalyzer: a,
+		outputs:          outputs,
+		stats:            newEncodingStats(a.topN),
+	}, nil
+}
+
+func (a *encodingAnalyzer) Close() ([]string, error) {
+	if a.sources < 2 {
+		return nil, nil
+	}
+	outputs, err := a.createOutputs("all")
+	if err != nil {
+		return nil, err
+	}
+	return a.writeStats(outputs, a.merged)
+}
+
+// writeStats 将统计写入各结果文件并关闭
+func (a *encodingAnalyzer) writeStats(outputs []*os.File, stats *encodingStats) ([]string, error) {
+	writers := []func(*csv.Writer) error{
+		stats.writeKeys,
+		func(w *csv.Writer) error { return stats.writePrefixes(w, a.topN) },
+	}
+	var paths []string
+	var err error
+	for i, outputFile := range outputs {
+		paths = append(paths, outputFile.Name())
+		if err == nil {
+			csvWriter := csv.NewWriter(outputFile)
+			if err = writers[i](csvWriter); err == nil {
+				csvWriter.Flush()
+				err = csvWriter.Error()
+			}
+		}
+		_ = outputFile.Close()
+	}
+	return paths, err
+}
+
+type encodingFileAnalyzer struct {
+	*encodingAnalyzer
+	outputs []*os.File
+	stats   *encodingStats
+}
+
+func (fa *encodingFileAnalyzer) Add(object model.RedisObject) {
+	advice := adviseEncoding(object, fa.limits)
+	if advice == nil {
+		return
+	}
+	key := advice.key
+	fa.stats.prefix(advice.db, key[:nextBoundary(key, fa.separators)], advice.typ).
+		add(1, advice.size, advice.saved, advice.needEntries, advice.needValue)
+	if fa.stats.keys.accepts(advice.saved) {
+		fa.stats.keys.add(advice)
+	}
+}
+
+func (fa *encodingFileAnalyzer) Finish() ([]string, error) {
+	// KEY明细在合并后共享，写入后不再修改
+	paths, err := fa.writeStats(fa.outputs, fa.stats)
+	fa.mu.Lock()
+	fa.sources++
+	fa.merged.merge(fa.stats)
+	fa.mu.Unlock()
+	return paths, err
+}
+
+func (s *encodingStats) writeKeys(w *csv.Writer) error {
+	header := []string{"数据库", "KEY", "类型", "当前编码", "元素个数", "最大元素", "KEY大小", "KEY大小[K/M/G]",
+		"目标编码", "未满足条件", "预计节省", "预计节省[K/M/G]", "调整配置", "调整数据"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, x := range s.keys.list {
+		a := x.(*encodingAdvice)
+		err := w.Write([]string{
+			strconv.Itoa(a.db),
+			a.key,
+			a.typ,
+			a.encoding,
+			strconv.Itoa(a.count),
+			strconv.Itoa(a.maxElement),
+			strconv.Itoa(a.size),
+			bytefmt.FormatSize(uint64(a.size)),
+			a.target,
+			strings.Join(a.reasons, "; "),
+			strconv.Itoa(a.saved),
+			bytefmt.FormatSize(uint64(a.saved)),
+			a.config,
+			a.reshape,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePrefixes 按预计节省从大到小输出前topN个前缀，需要的配置为覆盖该前缀所有KEY的最小阈值
+func (s *encodingStats) writePrefixes(w *csv.Writer, topN int) error {
+	list := make([]*encodingPrefix, 0, len(s.prefixes))
+	for _, p := range s.prefixes {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].saved != list[j].saved {
+			return list[i].saved > list[j].saved
+		}
+		if list[i].db != list[j].db {
+			return list[i].db < list[j].db
+		}
+		if list[i].prefix != list[j].prefix {
+			return list[i].prefix < list[j].prefix
+		}
+		return list[i].typ < list[j].typ
+	})
+	if len(list) > topN {
+		list = list[:topN]
+	}
+	header := []string{"数据库", "前缀", "类型", "KEY个数", "KEY大小", "KEY大小[K/M/G]", "预计节省", "预计节省[K/M/G]", "需要的entries", "需要的value"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, p := range list {
+		needEntries, needValue := "", ""
+		if p.needEntries > 0 {
+			needEntries = strconv.Itoa(p.needEntries)
+		}
+		if p.needValue > 0 {
+			needValue = strconv.Itoa(p.needValue)
+		}
+		err := w.Write([]string{
+			strconv.Itoa(p.db),
+			p.prefix,
+			p.typ,
+			strconv.Itoa(p.keyCount),
+			strconv.Itoa(p.totalSize),
+			bytefmt.FormatSize(uint64(p.totalSize)),
+			strconv.Itoa(p.saved),
+			bytefmt.FormatSize(uint64(p.saved)),
+			needEntries,
+			needValue,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
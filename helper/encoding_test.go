@@ -0,0 +1,96 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+func TestAdviseEncoding(t *testing.T) {
+	limits, err := encodingLimits(map[string]string{"hash-max-ziplist-entries": "4", "custom": "x"})
+	if err != nil || limits["hash-max-listpack-entries"] != 4 || limits["set-max-intset-entries"] != 512 {
+		t.Errorf("wrong limits: %v, %v", limits, err)
+		return
+	}
+	if _, err = encodingLimits(map[string]string{"zset-max-listpack-value": "-1"}); err == nil {
+		t.Error("expect error for negative limit")
+	}
+
+	hash := func(n int, value string) *model.HashObject {
+		o := &model.HashObject{BaseObject: &model.BaseObject{Key: "h", Size: 1000, Encoding: model.HashEncoding}, Hash: map[string][]byte{}}
+		for i := 0; i < n; i++ {
+			o.Hash["f"+strconv.Itoa(i)] = []byte(value)
+		}
+		return o
+	}
+	advice := adviseEncoding(hash(6, "v"), limits)
+	if advice == nil || advice.needEntries != 6 || advice.needValue != 0 ||
+		advice.config != "hash-max-listpack-entries 6" || advice.reshape != "拆分为 2 个KEY" || advice.saved <= 0 {
+		t.Errorf("wrong advice: %+v", advice)
+	}
+	if advice = adviseEncoding(hash(3, strings.Repeat("v", 100)), limits); advice == nil || advice.needValue != 100 ||
+		advice.reshape != "缩短或移出 3 个超过 64B 的元素" {
+		t.Errorf("wrong advice: %+v", advice)
+	}
+	// 已满足条件、远超阈值或已经是紧凑编码时不给出建议
+	for _, o := range []*model.HashObject{hash(3, "v"), hash(9, "v"), hash(3, strings.Repeat("v", 200))} {
+		if advice = adviseEncoding(o, limits); advice != nil {
+			t.Errorf("unexpected advice: %+v", advice)
+		}
+	}
+	compact := hash(6, "v")
+	compact.Encoding = model.ListPackEncoding
+	if advice = adviseEncoding(compact, limits); advice != nil {
+		t.Errorf("unexpected advice for compact encoding: %+v", advice)
+	}
+
+	set := &model.SetObject{BaseObject: &model.BaseObject{Key: "s", Size: 50000, Encoding: model.SetEncoding}}
+	for i := 0; i < 600; i++ {
+		set.Members = append(set.Members, []byte(strconv.Itoa(i)))
+	}
+	if advice = adviseEncoding(set, limits); advice == nil || advice.target != model.IntSetEncoding ||
+		advice.saved != 50000-8-600*2 {
+		t.Errorf("wrong intset advice: %+v", advice)
+	}
+}
+
+func TestEncodingAnalyse(t *testing.T) {
+	dir := t.TempDir()
+	cmds := [][]string{{"HSET", "user:1"}, {"HSET", "user:2"}}
+	for i := 0; i < 5; i++ {
+		for j := range cmds {
+			cmds[j] = append(cmds[j], "f"+strconv.Itoa(i), "v")
+		}
+	}
+	cmds = append(cmds, []string{"HSET", "small:1", "f", "v"})
+	src := writeTestAof(t, dir, "node.aof", cmds...)
+	workDir := filepath.Join(dir, "report")
+	_ = os.MkdirAll(workDir, os.ModePerm)
+	cfg := AnalyzeConfig{WorkDir: workDir, WorkDirName: "report", Params: map[string]string{"hash-max-listpack-entries": "4"}}
+	a, err := newEncodingAnalyzer(&cfg)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	files, _, err := analyseFile(src, []Analyzer{a})
+	if err != nil || len(files) != 2 {
+		t.Errorf("wrong output: %v, %v", files, err)
+		return
+	}
+	read := func(path string) []string {
+		content, _ := os.ReadFile(path)
+		return strings.Split(strings.TrimSpace(string(content)), "\n")
+	}
+	if keys := read(files[0]); len(keys) != 3 || !strings.HasPrefix(keys[1], "0,user:") ||
+		!strings.HasSuffix(keys[1], ",hash-max-listpack-entries 5,拆分为 2 个KEY") {
+		t.Errorf("wrong keys: %v", keys)
+	}
+	if prefixes := read(files[1]); len(prefixes) != 2 || !strings.HasPrefix(prefixes[1], "0,user:,hash,2,") ||
+		!strings.HasSuffix(prefixes[1], ",5,") {
+		t.Errorf("wrong prefixes: %v", prefixes)
+	}
+}
@@ -2,13 +2,11 @@ package helper
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
+	"sync"
 
-	"github.com/hdt3213/rdb/core"
 	"github.com/hdt3213/rdb/d3flame"
 	"github.com/hdt3213/rdb/model"
 )
@@ -16,84 +14,109 @@ import (
 // TrimThreshold is the min count of keys to enable trim
 var TrimThreshold = 1000
 
-// FlameGraph draws flamegraph in web page to analysis memory usage pattern
-func FlameGraph(rdbFiles []string, port int, separators []string, workDir string, workDirName string, options ...interface{}) error {
-	if len(rdbFiles) == 0 {
-		return errors.New("rdb files are required")
-	}
+// flameAnalyzer 将所有RDB文件的KEY按分隔符汇总为一棵火焰图树，报告打包后启动Web服务展示
+type flameAnalyzer struct {
+	separators []string
+	port       int
+	mu         sync.Mutex // 保护root和count，并行分析时各文件的树在Finish中合并
+	root       *d3flame.FlameItem
+	count      int
+	data       []byte
+}
+
+func newFlameAnalyzer(cfg *AnalyzeConfig) (Analyzer, error) {
+	port := cfg.Port
 	if port == 0 {
 		port = 16379 // default port
 	}
+	return &flameAnalyzer{
+		separators: cfg.Separators,
+		port:       port,
+		root:       newFlameRoot(),
+	}, nil
+}
 
-	// 创建根节点
-	root := &d3flame.FlameItem{
+// newFlameRoot 创建根节点
+func newFlameRoot() *d3flame.FlameItem {
+	return &d3flame.FlameItem{
 		Name:     "root",
 		Children: make(map[string]*d3flame.FlameItem),
 	}
-	var count int
-
-	fmt.Printf("📁 工作目录: %s\n", workDir)
-	fmt.Printf("📊 分析文件数量: %d\n", len(rdbFiles))
-	fmt.Printf("🌐 Web服务端口: %d\n\n", port)
+}
 
-	// 处理所有RDB文件
-	for i, rdbFilename := range rdbFiles {
-		fmt.Printf("[%d/%d] 正在处理: %s\n", i+1, len(rdbFiles), rdbFilename)
+func (a *flameAnalyzer) Begin(rdbFilename string) (FileAnalyzer, error) {
+	return &flameFileAnalyzer{flameAnalyzer: a, root: newFlameRoot()}, nil
+}
 
-		rdbFile, err := os.Open(rdbFilename)
-		if err != nil {
-			return fmt.Errorf("打开RDB文件 %s 失败: %v", rdbFilename, err)
-		}
+type flameFileAnalyzer struct {
+	*flameAnalyzer
+	root  *d3flame.FlameItem
+	count int
+}
 
-		var dec decoder = core.NewDecoder(rdbFile)
-		if dec, err = wrapDecoder(dec, options...); err != nil {
-			rdbFile.Close()
-			return err
-		}
+func (fa *flameFileAnalyzer) Add(object model.RedisObject) {
+	fa.count++
+	addObject(fa.root, fa.separators, object)
+}
 
-		err = dec.Parse(func(object model.RedisObject) bool {
-			count++
-			addObject(root, separators, object)
-			return true
-		})
-		rdbFile.Close()
+func (fa *flameFileAnalyzer) Finish() ([]string, error) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	fa.flameAnalyzer.count += fa.count
+	mergeFlame(fa.flameAnalyzer.root, fa.root)
+	return nil, nil
+}
 
-		if err != nil {
-			return fmt.Errorf("❌ 解析RDB文件 %s 失败: %v", rdbFilename, err)
+// mergeFlame 将src的子树累加到dst
+func mergeFlame(dst *d3flame.FlameItem, src *d3flame.FlameItem) {
+	for name, child := range src.Children {
+		target := dst.Children[name]
+		if target == nil {
+			dst.AddChild(child)
+			continue
 		}
-
-		fmt.Printf("  ✅ 完成\n")
+		target.Value += child.Value
+		mergeFlame(target, child)
 	}
+}
 
+func (a *flameAnalyzer) Close() ([]string, error) {
 	// 计算总大小
 	totalSize := 0
-	for _, v := range root.Children {
+	for _, v := range a.root.Children {
 		totalSize += v.Value
 	}
-	root.Value = totalSize
+	a.root.Value = totalSize
 
 	// 如果数据量大，进行裁剪
-	if count >= TrimThreshold {
-		trimData(root)
+	if a.count >= TrimThreshold {
+		trimData(a.root)
 	}
 
 	// 序列化数据
-	data, err := json.Marshal(root)
+	data, err := json.Marshal(a.root)
 	if err != nil {
-		return fmt.Errorf("序列化火焰图数据失败: %v", err)
+		return nil, fmt.Errorf("序列化火焰图数据失败: %v", err)
 	}
+	a.data = data
+	return nil, nil
+}
 
-	fmt.Println("==========================================")
-	fmt.Printf("🎉 火焰图分析完成，共处理 %d 个KEY\n", count)
-	fmt.Printf("🌐 Web服务已启动: http://localhost:%d\n", port)
+func (a *flameAnalyzer) Serve() {
+	fmt.Printf("🌐 火焰图Web服务已启动: http://localhost:%d\n", a.port)
 	fmt.Printf("⚠️  按 Ctrl+C 退出程序\n")
-
 	// 启动Web服务并等待用户停止
-	d3flame.Web(data, port)
+	d3flame.Web(a.data, a.port)
 	// 阻塞等待用户停止（通过Ctrl+C）
 	select {}
 }
 
+// FlameGraph draws flamegraph in web page to analysis memory usage pattern
+func FlameGraph(rdbFiles []string, port int, separators []string, workDir string, workDirName string, options ...interface{}) error {
+	cfg := AnalyzeConfig{WorkDir: workDir, WorkDirName: workDirName, Separators: separators, Port: port}
+	return Analyse("flamegraph", rdbFiles, cfg, options...)
+}
+
 func split(s string, separators []string) []string {
 	sep := ":"
 	if len(separators) > 0 {
@@ -0,0 +1,526 @@
+package helper
+
+import (
+	"container/heap"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hdt3213/rdb/bytefmt"
+	"github.com/hdt3213/rdb/model"
+)
+
+// maxTagEntities 跨slot分析时最多跟踪的实体数，如每个用户ID是一个实体，超过后新的实体不再统计
+var maxTagEntities = 1000000
+
+// maxTagGroups 最多跟踪的标签分组数，超过后使用Space-Saving算法替换大小最小的分组，大的标签分组不会被遗漏
+var maxTagGroups = 100000
+
+// maxTagTemplates 每个标签分组或实体模式最多记录的KEY模板数
+const maxTagTemplates = 3
+
+// tagGroup 使用同一个{标签}的KEY，集群中一定在同一个slot
+// 替换其他分组时继承其KEY个数和大小，overestimate为继承的大小，即大小的误差上限
+type tagGroup struct {
+	tag          string
+	keyCount     int
+	totalSize    int
+	overestimate int
+	templates    []string
+	index        int // 在堆中的位置
+}
+
+// tagGroups 按大小的最小堆，分组数达到上限时替换最小的分组
+type tagGroups struct {
+	items []*tagGroup
+	index map[string]*tagGroup
+}
+
+func (g *tagGroups) Len() int {
+	return len(g.items)
+}
+
+func (g *tagGroups) Less(i, j int) bool {
+	return g.items[i].totalSize < g.items[j].totalSize
+}
+
+func (g *tagGroups) Swap(i, j int) {
+	g.items[i], g.items[j] = g.items[j], g.items[i]
+	g.items[i].index = i
+	g.items[j].index = j
+}
+
+func (g *tagGroups) Push(x any) {
+	item := x.(*tagGroup)
+	item.index = len(g.items)
+	g.items = append(g.items, item)
+}
+
+func (g *tagGroups) Pop() any {
+	item := g.items[len(g.items)-1]
+	g.items = g.items[:len(g.items)-1]
+	return item
+}
+
+// add 将KEY计入标签分组，返回该分组用于记录模板
+func (g *tagGroups) add(tag string, keyCount, size, overestimate int) *tagGroup {
+	if item := g.index[tag]; item != nil {
+		item.keyCount += keyCount
+		item.totalSize += size
+		item.overestimate += overestimate
+		heap.Fix(g, item.index)
+		return item
+	}
+	if len(g.items) < maxTagGroups {
+		item := &tagGroup{tag: tag, keyCount: keyCount, totalSize: size, overestimate: overestimate}
+		g.index[tag] = item
+		heap.Push(g, item)
+		return item
+	}
+	item := g.items[0]
+	delete(g.index, item.tag)
+	item.tag = tag
+	item.overestimate = item.totalSize + overestimate
+	item.keyCount += keyCount
+	item.totalSize += size
+	item.templates = nil
+	g.index[tag] = item
+	heap.Fix(g, 0)
+	return item
+}
+
+// tagIssue 标签写法有问题的KEY
+type tagIssue struct {
+	db    int
+	key   string
+	typ   string
+	size  int
+	issue string
+}
+
+func (i *tagIssue) GetSize() int {
+	return i.size
+}
+
+// tagEntity 同一个实体(如 user:1000)的KEY落在的slot，slot为-1表示已经跨slot
+type tagEntity struct {
+	slot     int
+	keyCount int
+}
+
+// tagPattern 没有标签的KEY按第一个变化的段汇总，如 user:1000:profile 和 user:1000:following 属于模式 user:{id} 的实体 user:1000
+type tagPattern struct {
+	pattern   string
+	keyCount  int
+	totalSize int
+	templates []string
+	example   string
+	suggest   string
+	entities  map[string]*tagEntity
+}
+
+// hashtagStats 一个或多个数据源的标签统计，可以合并
+type hashtagStats struct {
+	totalSize int
+	slots     slotStats // 每个slot的KEY个数和大小，不受分组数上限影响
+	groups    *tagGroups
+	issues    *topList
+	patterns  map[string]*tagPattern
+	entities  int
+}
+
+func newHashtagStats(topN int) *hashtagStats {
+	return &hashtagStats{
+		groups:   &tagGroups{index: make(map[string]*tagGroup)},
+		issues:   newToplist(topN),
+		patterns: make(map[string]*tagPattern),
+	}
+}
+
+func (s *hashtagStats) pattern(pattern string) *tagPattern {
+	p, _ := capGroup(s.patterns, pattern, func(pattern string) string {
+		return pattern
+	}, func(pattern string) *tagPattern {
+		return &tagPattern{pattern: pattern, entities: make(map[string]*tagEntity)}
+	})
+	return p
+}
+
+// entity 记录实体的一个或多个KEY所在的slot，实体数超过maxTagEntities后不再记录新的实体
+func (s *hashtagStats) entity(p *tagPattern, name string, slot, keyCount int) {
+	e := p.entities[name]
+	if e == nil {
+		if s.entities >= maxTagEntities {
+			return
+		}
+		s.entities++
+		p.entities[name] = &tagEntity{slot: slot, keyCount: keyCount}
+		return
+	}
+	if e.slot != slot {
+		e.slot = -1
+	}
+	e.keyCount += keyCount
+}
+
+func addTemplate(templates []string, template string) []string {
+	if len(templates) >= maxTagTemplates {
+		return templates
+	}
+	for _, t := range templates {
+		if t == template {
+			return templates
+		}
+	}
+	return append(templates, template)
+}
+
+func (s *hashtagStats) merge(o *hashtagStats) {
+	s.totalSize += o.totalSize
+	for i := range o.slots {
+		s.slots[i].count += o.slots[i].count
+		s.slots[i].size += o.slots[i].size
+	}
+	for _, src := range o.groups.items {
+		g := s.groups.add(src.tag, src.keyCount, src.totalSize, src.overestimate)
+		for _, t := range src.templates {
+			g.templates = addTemplate(g.templates, t)
+		}
+	}
+	for _, x := range o.issues.list {
+		if s.issues.accepts(x.GetSize()) {
+			s.issues.add(x)
+		}
+	}
+	for _, src := range o.patterns {
+		p := s.pattern(src.pattern)
+		p.keyCount += src.keyCount
+		p.totalSize += src.totalSize
+		for _, t := range src.templates {
+			p.templates = addTemplate(p.templates, t)
+		}
+		if p.example == "" {
+			p.example, p.suggest = src.example, src.suggest
+		}
+		for name, e := range src.entities {
+			s.entity(p, name, e.slot, e.keyCount)
+		}
+	}
+}
+
+// tagProblem 检查KEY中{}的写法，返回问题描述，没有问题时返回空
+// Redis只使用第一个{与之后第一个}之间的内容，内容为空时使用整个KEY
+func tagProblem(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return ""
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	switch {
+	case end < 0:
+		return "{没有闭合，使用整个KEY计算slot"
+	case end == 0:
+		return "空标签{}，使用整个KEY计算slot"
+	case strings.IndexByte(key[start+1:start+1+end], '{') >= 0:
+		return "嵌套的{，标签为 " + key[start+1:start+1+end]
+	case strings.IndexByte(key[start+2+end:], '{') >= 0:
+		return "多个标签，只有第一个 {" + key[start+1:start+1+end] + "} 生效"
+	}
+	return ""
+}
+
+// entityOf 找出KEY中第一个变化的段，返回实体、实体模式和在该段加上标签的建议KEY
+// 如 user:1000:profile 返回 user:1000, user:{id}, user:{1000}:profile
+func entityOf(key string, separators []string) (entity, pattern, suggest string, ok bool) {
+	start := 0
+	for start < len(key) {
+		end, sepLen := len(key), 0
+		for _, sep := range separators {
+			if sep == "" {
+				continue
+			}
+			if i := strings.Index(key[start:end], sep); i >= 0 {
+				end, sepLen = start+i, len(sep)
+			}
+		}
+		seg := key[start:end]
+		if p := familyPattern(seg); p != seg {
+			return key[:end], key[:start] + p, key[:start] + "{" + seg + "}" + key[end:], true
+		}
+		start = end + sepLen
+	}
+	return "", "", "", false
+}
+
+// hashtagAnalyzer 迁移到集群前的标签分析: 最大的标签分组、标签写法有问题的KEY，
+// 以及同一实体的多个KEY没有使用标签而落在不同slot，无法在事务、Lua脚本和多KEY命令中一起使用
+// 多个数据源时另外输出所有数据源合并后的结果
+type hashtagAnalyzer struct {
+	cfg        *AnalyzeConfig
+	topN       int
+	separators []string
+	mu         sync.Mutex // 保护merged和sources
+	merged     *hashtagStats
+	sources    int
+}
+
+func newHashtagAnalyzer(cfg *AnalyzeConfig) (Analyzer, error) {
+	if cfg.TopN < 0 {
+		return nil, errors.New("结果数量必须大于0")
+	}
+	topN := cfg.TopN
+	if topN == 0 {
+		topN = 100
+	}
+	separators := cfg.separators()
+	return &hashtagAnalyzer{
+		cfg:        cfg,
+		topN:       topN,
+		separators: separators,
+		merged:     newHashtagStats(topN),
+	}, nil
+}
+
+var hashtagSuffixes = []string{"-hashtag.csv", "-hashtag-issue.csv", "-hashtag-cross.csv"}
+
+// createOutputs 创建标签分组、有问题的KEY和跨slot实体三个结果文件
+func (a *hashtagAnalyzer) createOutputs(src string) ([]*os.File, error) {
+	var outputs []*os.File
+	for _, suffix := range hashtagSuffixes {
+		_, outputFile, err := a.cfg.CreateOutput(src, suffix)
+		if err != nil {
+			for _, f := range outputs {
+				_ = f.Close()
+			}
+			return nil, fmt.Errorf("创建输出文件失败: %v", err)
+		}
+		outputs = append(outputs, outputFile)
+	}
+	return outputs, nil
+}
+
+func (a *hashtagAnalyzer) Begin(rdbFilename string) (FileAnalyzer, error) {
+	// 先创建文件占用文件名，分析完成后再写入
+	outputs, err := a.createOutputs(rdbFilename)
+	if err != nil {
+		return nil, err
+	}
+	return &hashtagFileAnalyzer{
+		hashtagAnalyzer: a,
+		outputs:         outputs,
+		stats:           newHashtagStats(a.topN),
+	}, nil
+}
+
+func (a *hashtagAnalyzer) Close() ([]string, error) {
+	if a.sources < 2 {
+		return nil, nil
+	}
+	outputs, err := a.createOutputs("all")
+	if err != nil {
+		return nil, err
+	}
+	return a.writeStats(outputs, a.merged)
+}
+
+// writeStats 将统计写入各结果文件并关闭
+func (a *hashtagAnalyzer) writeStats(outputs []*os.File, stats *hashtagStats) ([]string, error) {
+	writers := []func(*csv.Writer) error{
+		func(w *csv.Writer) error { return stats.writeGroups(w, a.topN) },
+		stats.writeIssues,
+		func(w *csv.Writer) error { return stats.writeCross(w, a.topN) },
+	}
+	var paths []string
+	var err error
+	for i, outputFile := range outputs {
+		paths = append(paths, outputFile.Name())
+		if err == nil {
+			csvWriter := csv.NewWriter(outputFile)
+			if err = writers[i](csvWriter); err == nil {
+				csvWriter.Flush()
+				err = csvWriter.Error()
+			}
+		}
+		_ = outputFile.Close()
+	}
+	return paths, err
+}
+
+type hashtagFileAnalyzer struct {
+	*hashtagAnalyzer
+	outputs []*os.File
+	stats   *hashtagStats
+}
+
+func (fa *hashtagFileAnalyzer) Add(object model.RedisObject) {
+	s := fa.stats
+	key := object.GetKey()
+	size := object.GetSize()
+	s.totalSize += size
+	slot := keyHashSlot(key)
+	s.slots[slot].count++
+	s.slots[slot].size += size
+	if problem := tagProblem(key); problem != "" && s.issues.accepts(size) {
+		s.issues.add(&tagIssue{db: object.GetDBIndex(), key: key, typ: object.GetType(), size: size, issue: problem})
+	}
+	if tag := hashTag(key); tag != key {
+		start := strings.IndexByte(key, '{')
+		g := s.groups.add(tag, 1, size, 0)
+		g.templates = addTemplate(g.templates, keyTemplate(key[:start], fa.separators)+"{"+tag+"}"+
+			keyTemplate(key[start+len(tag)+2:], fa.separators))
+		return
+	}
+	entity, pattern, suggest, ok := entityOf(key, fa.separators)
+	if !ok {
+		return
+	}
+	p := s.pattern(pattern)
+	p.keyCount++
+	p.totalSize += size
+	p.templates = addTemplate(p.templates, keyTemplate(key, fa.separators))
+	if p.example == "" {
+		p.example, p.suggest = key, suggest
+	}
+	s.entity(p, entity, slot, 1)
+}
+
+func (fa *hashtagFileAnalyzer) Finish() ([]string, error) {
+	// 有问题的KEY的记录在合并后共享，写入后不再修改
+	paths, err := fa.writeStats(fa.outputs, fa.stats)
+	fa.mu.Lock()
+	fa.sources++
+	fa.merged.merge(fa.stats)
+	fa.mu.Unlock()
+	return paths, err
+}
+
+// writeGroups 按大小从大到小输出前topN个标签分组，大小超过slot平均值hotSlotRatio倍的会形成热点slot
+// 分组大小可能包含被替换分组的大小，不超过所在slot的实际大小
+func (s *hashtagStats) writeGroups(w *csv.Writer, topN int) error {
+	list := make([]*tagGroup, 0, len(s.groups.items))
+	for _, g := range s.groups.items {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].totalSize != list[j].totalSize {
+			return list[i].totalSize > list[j].totalSize
+		}
+		return list[i].tag < list[j].tag
+	})
+	if len(list) > topN {
+		list = list[:topN]
+	}
+	avg := float64(s.totalSize) / slotCount
+	header := []string{"标签", "slot", "KEY个数", "KEY大小", "KEY大小[K/M/G]", "内存占比", "热点", "KEY模板", "slot大小", "KEY大小误差上限"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, g := range list {
+		slot := keyHashSlot("{" + g.tag + "}")
+		size, share, hot := min(g.totalSize, s.slots[slot].size), 0.0, ""
+		if float64(size) > avg*float64(hotSlotRatio) {
+			hot = "是"
+		}
+		if s.totalSize > 0 {
+			share = float64(size) * 100 / float64(s.totalSize)
+		}
+		err := w.Write([]string{
+			g.tag,
+			strconv.Itoa(slot),
+			strconv.Itoa(min(g.keyCount, s.slots[slot].count)),
+			strconv.Itoa(size),
+			bytefmt.FormatSize(uint64(size)),
+			strconv.FormatFloat(share, 'f', 1, 64) + "%",
+			hot,
+			strings.Join(g.templates, " "),
+			strconv.Itoa(s.slots[slot].size),
+			strconv.Itoa(min(g.overestimate, size)),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *hashtagStats) writeIssues(w *csv.Writer) error {
+	if err := w.Write([]string{"数据库", "KEY", "类型", "KEY大小", "KEY大小[K/M/G]", "slot", "问题"}); err != nil {
+		return err
+	}
+	for _, x := range s.issues.list {
+		i := x.(*tagIssue)
+		err := w.Write([]string{
+			strconv.Itoa(i.db),
+			i.key,
+			i.typ,
+			strconv.Itoa(i.size),
+			bytefmt.FormatSize(uint64(i.size)),
+			strconv.Itoa(keyHashSlot(i.key)),
+			i.issue,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCross 输出有实体跨slot的模式，按跨slot的实体数从多到少
+func (s *hashtagStats) writeCross(w *csv.Writer, topN int) error {
+	type crossRow struct {
+		*tagPattern
+		entities, multi, cross int
+	}
+	var rows []crossRow
+	for _, p := range s.patterns {
+		row := crossRow{tagPattern: p, entities: len(p.entities)}
+		for _, e := range p.entities {
+			if e.keyCount > 1 {
+				row.multi++
+			}
+			if e.slot < 0 {
+				row.cross++
+			}
+		}
+		if row.cross > 0 {
+			rows = append(rows, row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].cross != rows[j].cross {
+			return rows[i].cross > rows[j].cross
+		}
+		return rows[i].pattern < rows[j].pattern
+	})
+	if len(rows) > topN {
+		rows = rows[:topN]
+	}
+	header := []string{"实体模式", "KEY个数", "KEY大小", "KEY大小[K/M/G]", "实体数", "多KEY实体数", "跨slot实体数", "跨slot比例", "KEY模板", "示例KEY", "建议KEY"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		err := w.Write([]string{
+			r.pattern,
+			strconv.Itoa(r.keyCount),
+			strconv.Itoa(r.totalSize),
+			bytefmt.FormatSize(uint64(r.totalSize)),
+			strconv.Itoa(r.entities),
+			strconv.Itoa(r.multi),
+			strconv.Itoa(r.cross),
+			strconv.FormatFloat(float64(r.cross)*100/float64(r.multi), 'f', 1, 64) + "%",
+			strings.Join(r.templates, " "),
+			r.example,
+			r.suggest,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
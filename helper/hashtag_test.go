@@ -0,0 +1,95 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTagProblem(t *testing.T) {
+	cases := map[string]string{
+		"user:{1000}:profile": "",
+		"user:1000":           "",
+		"user:{1000":          "{没有闭合，使用整个KEY计算slot",
+		"user:{}:1000":        "空标签{}，使用整个KEY计算slot",
+		"foo{{bar}}zap":       "嵌套的{，标签为 {bar",
+		"foo{bar}{zap}":       "多个标签，只有第一个 {bar} 生效",
+	}
+	for key, problem := range cases {
+		if got := tagProblem(key); got != problem {
+			t.Errorf("problem of %q: expect %q, got %q", key, problem, got)
+		}
+	}
+}
+
+func TestEntityOf(t *testing.T) {
+	entity, pattern, suggest, ok := entityOf("user:1000:profile", []string{":"})
+	if !ok || entity != "user:1000" || pattern != "user:{id}" || suggest != "user:{1000}:profile" {
+		t.Errorf("wrong entity: %s, %s, %s", entity, pattern, suggest)
+	}
+	if _, _, _, ok = entityOf("config:global", []string{":"}); ok {
+		t.Error("key without variable segment has no entity")
+	}
+}
+
+func TestHashtagAnalyse(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTestAof(t, dir, "node.aof",
+		[]string{"SET", "order:{42}:items", strings.Repeat("x", 100)},
+		[]string{"SET", "order:{42}:state", "1"},
+		[]string{"SET", "order:{7}:items", "1"},
+		[]string{"SET", "cart:{}:1", "1"},
+		// 同一用户的两个KEY落在不同slot，另一个用户只有一个KEY
+		[]string{"SET", "user:1000:profile", "1"},
+		[]string{"SET", "user:1000:following", "1"},
+		[]string{"SET", "user:2000:profile", "1"},
+	)
+	workDir := filepath.Join(dir, "report")
+	_ = os.MkdirAll(workDir, os.ModePerm)
+	cfg := AnalyzeConfig{WorkDir: workDir, WorkDirName: "report"}
+	a, err := newHashtagAnalyzer(&cfg)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	files, _, err := analyseFile(src, []Analyzer{a})
+	if err != nil || len(files) != 3 {
+		t.Errorf("wrong output: %v, %v", files, err)
+		return
+	}
+	read := func(path string) []string {
+		content, _ := os.ReadFile(path)
+		return strings.Split(strings.TrimSpace(string(content)), "\n")
+	}
+	groups := read(files[0])
+	if len(groups) != 3 || !strings.HasPrefix(groups[1], "42,8000,2,") ||
+		!strings.Contains(groups[1], ",是,") || !strings.Contains(groups[1], "order:{42}:items") ||
+		!strings.Contains(groups[1], "order:{42}:state") {
+		t.Errorf("wrong groups: %v", groups)
+	}
+	if issues := read(files[1]); len(issues) != 2 || !strings.HasPrefix(issues[1], "0,cart:{}:1,string,") {
+		t.Errorf("wrong issues: %v", issues)
+	}
+	cross := read(files[2])
+	if len(cross) != 2 || !strings.HasPrefix(cross[1], "user:{id},3,") || !strings.Contains(cross[1], ",2,1,1,100.0%,") ||
+		!strings.Contains(cross[1], ",user:{1000}:") {
+		t.Errorf("wrong cross slot patterns: %v", cross)
+	}
+}
+
+func TestTagGroupsLimit(t *testing.T) {
+	limit := maxTagGroups
+	maxTagGroups = 2
+	defer func() { maxTagGroups = limit }()
+	s := newHashtagStats(10)
+	for _, tag := range []string{"a", "b", "c", "d"} {
+		s.groups.add(tag, 1, 10, 0)
+	}
+	// 分组数达到上限后出现的大标签不会被遗漏
+	s.groups.add("monster", 1, 1000, 0)
+	monster := s.groups.index["monster"]
+	if len(s.groups.items) != 2 || monster == nil || monster.totalSize-monster.overestimate != 1000 {
+		t.Errorf("monster tag should be kept: %+v", s.groups.items)
+	}
+}
@@ -6,156 +6,273 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/hdt3213/rdb/bytefmt"
-	"github.com/hdt3213/rdb/core"
 	"github.com/hdt3213/rdb/model"
 )
 
-func prefixIt(rdbFilename string, outputFile *os.File, csvWriter *csv.Writer, topN int, maxDepth int, closeOutput bool, options ...interface{}) error {
-	// decode rdb file
-	rdbFile, err := os.Open(rdbFilename)
-	if err != nil {
-		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+// 前缀分析的切分方式
+const (
+	PrefixBySeparator = "sep"  // 按分隔符切分，前缀都以分隔符结尾
+	PrefixByChar      = "char" // 按字符切分，任意公共前缀都是一个节点，如 user:1 和 user:12
+)
+
+// prefixTypes 前缀明细中单独统计的类型，其余(如module)计入other
+var prefixTypes = [...]string{model.StringType, model.ListType, model.SetType, model.HashType, model.ZSetType, model.StreamType, "other"}
+
+// sizeBuckets KEY大小分布的区间上限，按4倍递增，最后一个区间为 >=1M
+var sizeBuckets = [...]int{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+// maxPrefixGroups 按第一段前缀汇总时的最大前缀数，KEY没有分隔符时每个KEY都是一个前缀，超过后计入(other)
+var maxPrefixGroups = 100000
+
+const otherPrefix = "(other)"
+
+// defaultSeparators 没有指定分隔符时按 : 切分
+func defaultSeparators(separators []string) []string {
+	if len(separators) == 0 {
+		return []string{":"}
 	}
-	defer func() {
-		_ = rdbFile.Close()
-	}()
-	if closeOutput {
-		defer func() {
-			_ = outputFile.Close()
-		}()
-	}
-	defer csvWriter.Flush()
-	var dec decoder = core.NewDecoder(rdbFile)
-	if dec, err = wrapDecoder(dec, options...); err != nil {
-		return err
-	}
-
-	// prefix tree
-	tree := newRadixTree()
-	err = dec.Parse(func(object model.RedisObject) bool {
-		key := genKey(object.GetDBIndex(), object.GetKey())
-		tree.insert(key, object.GetSize())
-		return true
-	})
-	if err != nil {
-		return err
+	return separators
+}
+
+// capGroup 返回name对应的分组，不存在时用create创建，key由名称得到map中的key
+// 分组数达到maxPrefixGroups后新的分组计入(other)，避免KEY没有分隔符或含有未识别的变化段时每个KEY产生一个分组
+// created表示返回的分组是新创建的
+func capGroup[T any](groups map[string]*T, name string, key func(name string) string, create func(name string) *T) (group *T, created bool) {
+	k := key(name)
+	if g := groups[k]; g != nil {
+		return g, false
+	}
+	if len(groups) >= maxPrefixGroups && name != otherPrefix {
+		name, k = otherPrefix, key(otherPrefix)
+		if g := groups[k]; g != nil {
+			return g, false
+		}
 	}
+	g := create(name)
+	groups[k] = g
+	return g, true
+}
 
-	// get top list
-	topListO := newToplist(topN)
-	tree.traverse(func(node *radixNode, depth int) bool {
-		if depth > maxDepth {
-			return false
+// keyStat 插入前缀树的单个KEY的统计信息
+type keyStat struct {
+	typ      string
+	size     int
+	volatile bool // 设置了过期时间
+}
+
+// prefixDetail 前缀下KEY的明细: 各类型的个数和大小、过期KEY、最大KEY和大小分布
+// 只有一个KEY的节点(如所有叶子节点)不分配明细，由节点上的类型和过期标记还原，使用定长数组避免额外的内存分配
+type prefixDetail struct {
+	typeCounts    [len(prefixTypes)]int
+	typeSizes     [len(prefixTypes)]int
+	volatileCount int
+	volatileSize  int
+	maxSize       int
+	histogram     [len(sizeBuckets) + 1]int
+}
+
+// prefixTypeIndex 类型在prefixTypes中的位置，未单独统计的类型返回other
+func prefixTypeIndex(typ string) int {
+	for i, t := range prefixTypes[:len(prefixTypes)-1] {
+		if t == typ {
+			return i
 		}
-		if depth <= 2 {
-			// skip root and database root
-			return true
+	}
+	return len(prefixTypes) - 1
+}
+
+func (d *prefixDetail) add(t int, size int, volatile bool) {
+	d.typeCounts[t]++
+	d.typeSizes[t] += size
+	if volatile {
+		d.volatileCount++
+		d.volatileSize += size
+	}
+	if size > d.maxSize {
+		d.maxSize = size
+	}
+	b := sort.SearchInts(sizeBuckets[:], size+1)
+	d.histogram[b]++
+}
+
+// addKey 将KEY计入节点，第二个KEY加入时才分配明细
+func (n *radixNode) addKey(stat keyStat) {
+	t := prefixTypeIndex(stat.typ)
+	if n.keyCount == 0 {
+		n.typ, n.volatile = uint8(t), stat.volatile
+	} else {
+		if n.detail == nil {
+			n.detail = n.prefixDetail()
 		}
-		topListO.add(node)
-		return true
-	})
-	printNode := func(node *radixNode) error {
-		db, key := parseNodeKey(node.fullpath)
-		dbStr := strconv.Itoa(db)
-		return csvWriter.Write([]string{
-			dbStr,
-			key,
-			strconv.Itoa(node.totalSize),
-			bytefmt.FormatSize(uint64(node.totalSize)),
-			strconv.Itoa(node.keyCount),
-		})
+		n.detail.add(t, stat.size, stat.volatile)
 	}
-	for _, n := range topListO.list {
-		node := n.(*radixNode)
-		err := printNode(node)
-		if err != nil {
-			return err
+	n.totalSize += stat.size
+	n.keyCount++
+}
+
+// prefixDetail 返回节点的明细，只有一个KEY的节点临时构造
+func (n *radixNode) prefixDetail() *prefixDetail {
+	if n.detail != nil {
+		return n.detail
+	}
+	d := &prefixDetail{}
+	if n.keyCount == 1 {
+		d.add(int(n.typ), n.totalSize, n.volatile)
+	}
+	return d
+}
+
+// prefixHeader 前五列与之前的版本保持一致
+func prefixHeader() string {
+	header := []string{"数据库", "前缀", "KEY大小", "KEY大小[K/M/G]", "个数", "平均大小", "最大大小", "永久KEY个数", "永久KEY占比", "过期KEY个数", "过期KEY大小"}
+	for _, typ := range prefixTypes {
+		header = append(header, typ+"个数", typ+"大小")
+	}
+	for i := range sizeBuckets {
+		if i == 0 {
+			header = append(header, "<"+bytefmt.FormatSize(uint64(sizeBuckets[i])))
+		} else {
+			header = append(header, bytefmt.FormatSize(uint64(sizeBuckets[i-1]))+"-"+bytefmt.FormatSize(uint64(sizeBuckets[i])))
 		}
 	}
-	return nil
+	header = append(header, ">="+bytefmt.FormatSize(uint64(sizeBuckets[len(sizeBuckets)-1])))
+	return strings.Join(header, ",") + "\n"
 }
 
-// PrefixAnalyse read rdb file and find the largest N keys.
-// The invoker owns output, FindBiggestKeys won't close it
-func PrefixAnalyse(rdbFiles []string, topN int, maxDepth int, workDir string, workDirName string, options ...interface{}) error {
-	fmt.Println("🔍 启动前缀分析任务")
-	fmt.Println("==========================================")
+func prefixRow(node *radixNode) []string {
+	db, key := parseNodeKey(node.fullpath)
+	d := node.prefixDetail()
+	persistent := node.keyCount - d.volatileCount
+	row := []string{
+		strconv.Itoa(db),
+		key,
+		strconv.Itoa(node.totalSize),
+		bytefmt.FormatSize(uint64(node.totalSize)),
+		strconv.Itoa(node.keyCount),
+		strconv.Itoa(node.totalSize / node.keyCount),
+		strconv.Itoa(d.maxSize),
+		strconv.Itoa(persistent),
+		strconv.FormatFloat(float64(persistent)*100/float64(node.keyCount), 'f', 1, 64) + "%",
+		strconv.Itoa(d.volatileCount),
+		strconv.Itoa(d.volatileSize),
+	}
+	for i := range prefixTypes {
+		row = append(row, strconv.Itoa(d.typeCounts[i]), strconv.Itoa(d.typeSizes[i]))
+	}
+	for _, n := range d.histogram {
+		row = append(row, strconv.Itoa(n))
+	}
+	return row
+}
 
-	if topN < 0 {
-		return errors.New("❌ 错误: 结果数量必须大于0")
-	} else if topN == 0 {
-		topN = math.MaxInt
+// prefixAnalyzer 按KEY前缀汇总内存占用，输出最大的N个前缀
+type prefixAnalyzer struct {
+	workDir    string
+	topN       int
+	maxDepth   int
+	mode       string
+	separators []string
+}
+
+func newPrefixAnalyzer(cfg *AnalyzeConfig) (Analyzer, error) {
+	a := &prefixAnalyzer{workDir: cfg.WorkDir, topN: cfg.TopN, maxDepth: cfg.MaxDepth, mode: cfg.PrefixMode, separators: cfg.Separators}
+	if a.topN < 0 {
+		return nil, errors.New("结果数量必须大于0")
+	} else if a.topN == 0 {
+		a.topN = math.MaxInt
+	}
+	switch a.mode {
+	case "":
+		a.mode = PrefixBySeparator
+	case PrefixBySeparator, PrefixByChar:
+	default:
+		return nil, fmt.Errorf("不支持的前缀切分方式: %s，可选值: sep, char", a.mode)
 	}
-	if maxDepth == 0 {
-		maxDepth = math.MaxInt
+	if a.maxDepth == 0 {
+		a.maxDepth = math.MaxInt
 	} else {
-		maxDepth += 2 // for root(depth==1) and database root(depth==2)
-	}
-
-	fmt.Printf("📁 工作目录: %s\n", workDir)
-	fmt.Printf("📊 分析文件数量: %d\n", len(rdbFiles))
-	fmt.Printf("🎯 显示TOP %d 前缀 (最大深度: %d)\n\n",
-		func() int {
-			if topN == math.MaxInt {
-				return -1
-			} else {
-				return topN
-			}
-		}(),
-		func() int {
-			if maxDepth-2 == math.MaxInt {
-				return -1
-			} else {
-				return maxDepth - 2
-			}
-		}())
-
-	var outputFiles []string // 用于收集生成的文件路径，后续压缩
-
-	for i, rdbFilename := range rdbFiles {
-		fmt.Printf("[%d/%d] 正在分析: %s\n", i+1, len(rdbFiles), rdbFilename)
-
-		outputPath, outputFile, err := createOutPath(rdbFilename, workDir, "-prefix.csv", false)
-		if err != nil {
-			return fmt.Errorf("❌ 创建输出文件失败: %v", err)
-		}
+		a.maxDepth += 2 // for root(depth==1) and database root(depth==2)
+	}
+	return a, nil
+}
 
-		// 收集输出文件路径
-		outputFiles = append(outputFiles, outputPath)
+func (a *prefixAnalyzer) Begin(rdbFilename string) (FileAnalyzer, error) {
+	// 先创建文件占用文件名，分析完成后再写入
+	outputPath, outputFile, err := createOutPath(rdbFilename, a.workDir, "-prefix.csv", false)
+	if err != nil {
+		return nil, fmt.Errorf("创建输出文件失败: %v", err)
+	}
+	return &prefixFileAnalyzer{prefixAnalyzer: a, outputPath: outputPath, outputFile: outputFile, tree: a.newTree()}, nil
+}
 
-		// 写入CSV头部
-		_, err = outputFile.WriteString("数据库,前缀,KEY大小,KEY大小[K/M/G],个数\n")
-		if err != nil {
-			return fmt.Errorf("❌ 写入CSV头部失败: %v", err)
-		}
+func (a *prefixAnalyzer) newTree() prefixTree {
+	if a.mode == PrefixByChar {
+		return newRadixTree()
+	}
+	return newSegmentTree(a.separators)
+}
 
-		csvWriter := csv.NewWriter(outputFile)
-		err = prefixIt(rdbFilename, outputFile, csvWriter, topN, maxDepth, true, options...)
-		if err != nil {
-			return fmt.Errorf("❌ 分析RDB文件失败: %v", err)
+func (a *prefixAnalyzer) Close() ([]string, error) {
+	return nil, nil
+}
+
+type prefixFileAnalyzer struct {
+	*prefixAnalyzer
+	outputPath string
+	outputFile *os.File
+	tree       prefixTree
+}
+
+func (fa *prefixFileAnalyzer) Add(object model.RedisObject) {
+	key := genKey(object.GetDBIndex(), object.GetKey())
+	fa.tree.insertKey(key, keyStat{
+		typ:      object.GetType(),
+		size:     object.GetSize(),
+		volatile: object.GetExpiration() != nil,
+	})
+}
+
+func (fa *prefixFileAnalyzer) Finish() ([]string, error) {
+	// get top list
+	topListO := newToplist(fa.topN)
+	fa.tree.traverse(func(node *radixNode, depth int) bool {
+		if depth > fa.maxDepth {
+			return false
+		}
+		if depth <= 2 {
+			// skip root and database root
+			return true
 		}
+		topListO.add(node)
+		return true
+	})
 
-		fmt.Printf("  ✅ 完成 -> %s\n", outputPath)
+	outputFile := fa.outputFile
+	defer func() {
+		_ = outputFile.Close()
+	}()
+	_, err := outputFile.WriteString(prefixHeader())
+	if err != nil {
+		return []string{fa.outputPath}, fmt.Errorf("写入CSV头部失败: %v", err)
 	}
-
-	fmt.Println("\n📦 正在打包报告文件...")
-	// 压缩输出文件
-	if len(outputFiles) > 0 {
-		zipPath := generateZipName(workDir, workDirName)
-		err := compressFiles(outputFiles, zipPath)
+	csvWriter := csv.NewWriter(outputFile)
+	for _, n := range topListO.list {
+		err = csvWriter.Write(prefixRow(n.(*radixNode)))
 		if err != nil {
-			fmt.Printf("❌ 压缩失败: %v\n", err)
-		} else {
-			fmt.Printf("✅ 压缩完成: %s\n", zipPath)
-			// 清理原始文件
-			cleanupFiles(outputFiles)
+			return []string{fa.outputPath}, err
 		}
 	}
+	csvWriter.Flush()
+	return []string{fa.outputPath}, csvWriter.Error()
+}
 
-	fmt.Println("==========================================")
-	fmt.Printf("🎉 前缀分析任务完成，共分析 %d 个RDB文件\n", len(rdbFiles))
-	return nil
+// PrefixAnalyse read rdb file and find the largest N prefixes.
+func PrefixAnalyse(rdbFiles []string, topN int, maxDepth int, workDir string, workDirName string, options ...interface{}) error {
+	cfg := AnalyzeConfig{WorkDir: workDir, WorkDirName: workDirName, TopN: topN, MaxDepth: maxDepth}
+	return Analyse("prefix", rdbFiles, cfg, options...)
 }
@@ -9,10 +9,14 @@ import (
 type radixNode struct {
 	path      string
 	end       bool
+	typ       uint8 // 只有一个KEY时，KEY的类型在prefixTypes中的位置
+	volatile  bool  // 只有一个KEY时，KEY是否设置了过期时间
 	children  []*radixNode
 	totalSize int // total size of all key-value with this prefix
 	keyCount  int
 	fullpath  string
+	detail    *prefixDetail         // 前缀下KEY的类型、过期和大小分布，有两个以上KEY时才分配
+	index     map[string]*radixNode // 按分隔符切分时子节点较多才建立，path -> 子节点
 }
 
 type radixTree struct {
@@ -34,6 +38,10 @@ func commonPrefixLen(wordA, wordB string) int {
 }
 
 func (tree *radixTree) insert(word string, size int) {
+	tree.insertKey(word, keyStat{size: size})
+}
+
+func (tree *radixTree) insertKey(word string, stat keyStat) {
 	root := tree.root
 	fullword := word
 	node := root
@@ -50,6 +58,13 @@ walk:
 				totalSize: node.totalSize,
 				keyCount:  node.keyCount,
 				fullpath:  node.fullpath,
+				typ:       node.typ,
+				volatile:  node.volatile,
+			}
+			if node.detail != nil {
+				// 拆分后两个节点分别累加，不能共享明细
+				detail := *node.detail
+				newChild.detail = &detail
 			}
 			node.children = []*radixNode{newChild}
 			node.fullpath = node.fullpath[:len(node.fullpath)-(len(node.path)-i)]
@@ -58,8 +73,7 @@ walk:
 		}
 		// word must be a descendants of node
 		if i > 0 || node == root {
-			node.totalSize += size
-			node.keyCount++
+			node.addKey(stat)
 		}
 		if i == len(word) {
 			// assert node.fullpath == fullword
@@ -82,8 +96,7 @@ walk:
 			child.path = word
 			child.end = true
 			child.fullpath = fullword
-			child.totalSize = size
-			child.keyCount = 1
+			child.addKey(stat)
 			node.children = append(node.children, child)
 			return
 		}
@@ -133,3 +146,95 @@ func parseNodeKey(key string) (int, string) {
 	}
 	return db, realKey
 }
+
+// prefixTree 前缀分析使用的树，节点的fullpath为 "db key前缀"
+type prefixTree interface {
+	insertKey(word string, stat keyStat)
+	traverse(cb func(node *radixNode, depth int) bool)
+}
+
+// segmentTree 按分隔符切分KEY的前缀树，每个节点的前缀都以分隔符结尾(叶子节点为完整的KEY)
+// 如 user:12:name 依次产生 user:、user:12:、user:12:name 三个节点，不会出现 user:1 这样的前缀
+type segmentTree struct {
+	root       *radixNode
+	separators []string
+}
+
+// childIndexThreshold 子节点超过该数量时建立索引，较少时顺序查找
+const childIndexThreshold = 8
+
+// child 按path查找子节点
+func (n *radixNode) child(path string) *radixNode {
+	if n.index != nil {
+		return n.index[path]
+	}
+	for _, child := range n.children {
+		if child.path == path {
+			return child
+		}
+	}
+	return nil
+}
+
+func (n *radixNode) addChild(child *radixNode) {
+	n.children = append(n.children, child)
+	if n.index != nil {
+		n.index[child.path] = child
+	} else if len(n.children) > childIndexThreshold {
+		n.index = make(map[string]*radixNode, len(n.children))
+		for _, c := range n.children {
+			n.index[c.path] = c
+		}
+	}
+}
+
+func newSegmentTree(separators []string) *segmentTree {
+	return &segmentTree{
+		root:       &radixNode{},
+		separators: defaultSeparators(separators),
+	}
+}
+
+// nextBoundary 返回s中第一个分隔符之后的位置，没有分隔符时返回len(s)
+func nextBoundary(s string, separators []string) int {
+	end := len(s)
+	for _, sep := range separators {
+		if sep == "" {
+			continue
+		}
+		if i := strings.Index(s[:end], sep); i >= 0 && i+len(sep) < end {
+			end = i + len(sep)
+		}
+	}
+	return end
+}
+
+// insert word的格式与genKey相同，第一段为 "db "，之后按分隔符切分
+func (tree *segmentTree) insert(word string, size int) {
+	tree.insertKey(word, keyStat{size: size})
+}
+
+func (tree *segmentTree) insertKey(word string, stat keyStat) {
+	node := tree.root
+	node.addKey(stat)
+	i := strings.Index(word, " ") + 1
+	for {
+		path := word[len(node.fullpath):i]
+		child := node.child(path)
+		if child == nil {
+			child = &radixNode{path: path, fullpath: word[:i]}
+			node.addChild(child)
+		}
+		child.addKey(stat)
+		node = child
+		if i == len(word) {
+			node.end = true
+			return
+		}
+		i += nextBoundary(word[i:], tree.separators)
+	}
+}
+
+func (tree *segmentTree) traverse(cb func(node *radixNode, depth int) bool) {
+	(&radixTree{root: tree.root}).traverse(cb)
+}
@@ -1,6 +1,10 @@
 package helper
 
-import "testing"
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
 
 func TestRadix(t *testing.T) {
 	words := []string{
@@ -36,3 +40,104 @@ func TestRadix(t *testing.T) {
 		}
 	}
 }
+
+func TestSegmentTree(t *testing.T) {
+	words := []string{
+		genKey(0, "user:1:name"),
+		genKey(0, "user:12:name"),
+		genKey(0, "user:12"),
+		genKey(0, "order.1"),
+		genKey(1, "counter"),
+	}
+	tree := newSegmentTree([]string{":", "."})
+	for i, word := range words {
+		tree.insert(word, i+1)
+	}
+	expectSizeMap := map[string]int{
+		"":               15,
+		"0 ":             10,
+		"0 user:":        6,
+		"0 user:1:":      1,
+		"0 user:12:":     2,
+		"0 user:12":      3,
+		"0 order.":       4,
+		"0 order.1":      4,
+		"1 ":             5,
+		"1 counter":      5,
+		"0 user:12:name": 2,
+		"0 user:1:name":  1,
+	}
+	actualSizeMap := make(map[string]int)
+	tree.traverse(func(node *radixNode, depth int) bool {
+		actualSizeMap[node.fullpath] = node.totalSize
+		return true
+	})
+	if len(actualSizeMap) != len(expectSizeMap) {
+		t.Errorf("wrong prefixes: %v", actualSizeMap)
+	}
+	for prefix, expectSize := range expectSizeMap {
+		if actualSize := actualSizeMap[prefix]; expectSize != actualSize {
+			t.Errorf("wrong size for %q: expect %d, got %d", prefix, expectSize, actualSize)
+		}
+	}
+
+	// 子节点较多时按索引查找，同一前缀再次插入不会产生重复节点
+	tree = newSegmentTree(nil)
+	for round := 0; round < 2; round++ {
+		for i := 0; i < childIndexThreshold*2; i++ {
+			tree.insert(genKey(0, "item:"+strconv.Itoa(i)+":v"), 1)
+		}
+	}
+	var items *radixNode
+	tree.traverse(func(node *radixNode, depth int) bool {
+		if node.fullpath == "0 item:" {
+			items = node
+		}
+		return true
+	})
+	if items == nil || items.index == nil || len(items.children) != childIndexThreshold*2 || items.keyCount != childIndexThreshold*4 {
+		t.Errorf("wrong children: %+v", items)
+	}
+}
+
+func TestPrefixDetail(t *testing.T) {
+	stats := []keyStat{
+		{typ: "string", size: 10},
+		{typ: "hash", size: 300, volatile: true},
+		{typ: "ReJSON-RL", size: 2 << 20},
+	}
+	for _, tree := range []prefixTree{newRadixTree(), newSegmentTree(nil)} {
+		tree.insertKey(genKey(0, "cache:a"), stats[0])
+		tree.insertKey(genKey(0, "cache:ab"), stats[1])
+		tree.insertKey(genKey(0, "cache:b"), stats[2])
+		var node, leaf *radixNode
+		tree.traverse(func(n *radixNode, depth int) bool {
+			switch n.fullpath {
+			case "0 cache:":
+				node = n
+			case "0 cache:ab":
+				leaf = n
+			}
+			return true
+		})
+		// 只有一个KEY的节点不分配明细，输出时由类型和过期标记还原
+		if leaf == nil || leaf.detail != nil ||
+			!strings.HasPrefix(strings.Join(prefixRow(leaf), ","), "0,cache:ab,300,300B,1,300,300,0,0.0%,1,300,0,0,0,0,0,0,1,300,") {
+			t.Errorf("wrong leaf: %+v", leaf)
+		}
+		if node == nil {
+			t.Error("prefix cache: not found")
+			continue
+		}
+		row := strings.Join(prefixRow(node), ",")
+		expect := "0,cache:,2097462,2M,3,699154,2097152,2,66.7%,1,300," +
+			"1,10,0,0,0,0,1,300,0,0,0,0,1,2097152," +
+			"1,0,1,0,0,0,0,0,1"
+		if row != expect {
+			t.Errorf("wrong prefix row:\nexpect %s\ngot    %s", expect, row)
+		}
+		if header := strings.Split(prefixHeader(), ","); len(header) != len(prefixRow(node)) {
+			t.Errorf("header has %d columns, row has %d", len(header), len(prefixRow(node)))
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// replDialTimeout 建立TCP连接的超时时间
+	replDialTimeout = 10 * time.Second
+	// eofMarkLen 无盘复制(diskless)模式下EOF标记的长度
+	eofMarkLen = 40
+)
+
+// replReadTimeout 单次读取的超时时间，主节点BGSAVE期间只会发送换行保活
+var replReadTimeout = 5 * time.Minute
+
+// replicaConn 模拟一个从节点，通过复制协议从Redis节点拉取RDB
+type replicaConn struct {
+	addr     string
+	username string
+	password string
+	conn     net.Conn
+	reader   *bufio.Reader
+}
+
+// dialReplica 按连接配置(认证、TLS)连接到addr
+func (rc *RedisConnection) dialReplica(addr string) (*replicaConn, error) {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: replDialTimeout}
+	if rc.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, rc.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("连接 %s 失败: %v", addr, err)
+	}
+	return &replicaConn{
+		addr:     addr,
+		username: rc.Username,
+		password: rc.Password,
+		conn:     conn,
+		reader:   bufio.NewReader(conn),
+	}, nil
+}
+
+func (r *replicaConn) Close() error {
+	return r.conn.Close()
+}
+
+// send 以RESP格式发送命令
+func (r *replicaConn) send(args ...string) error {
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	_ = r.conn.SetWriteDeadline(time.Now().Add(replDialTimeout))
+	_, err := r.conn.Write(makeMultiBulkResp(cmdLine))
+	return err
+}
+
+// readLine 读取一行回复，跳过主节点在生成RDB期间发送的保活换行
+func (r *replicaConn) readLine() (string, error) {
+	for {
+		_ = r.conn.SetReadDeadline(time.Now().Add(replReadTimeout))
+		line, err := r.reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			return line, nil
+		}
+	}
+}
+
+// command 发送命令并读取单行回复，错误回复转换为error
+func (r *replicaConn) command(args ...string) (string, error) {
+	if err := r.send(args...); err != nil {
+		return "", err
+	}
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(line, "-") {
+		return "", errors.New(line[1:])
+	}
+	return line, nil
+}
+
+// handshake 完成复制握手：AUTH(支持ACL用户名)、REPLCONF，然后发起PSYNC(不支持时回退为SYNC)
+func (r *replicaConn) handshake() error {
+	if r.password != "" {
+		args := []string{"AUTH", r.password}
+		if r.username != "" {
+			args = []string{"AUTH", r.username, r.password}
+		}
+		if _, err := r.command(args...); err != nil {
+			return fmt.Errorf("认证失败: %v", err)
+		}
+	}
+	if _, err := r.command("PING"); err != nil {
+		return fmt.Errorf("PING失败: %v", err)
+	}
+	// listening-port 仅用于主节点INFO展示，这里上报本地端口
+	localPort := "0"
+	if tcpAddr, ok := r.conn.LocalAddr().(*net.TCPAddr); ok {
+		localPort = strconv.Itoa(tcpAddr.Port)
+	}
+	if _, err := r.command("REPLCONF", "listening-port", localPort); err != nil {
+		return fmt.Errorf("REPLCONF listening-port失败: %v", err)
+	}
+	// 老版本不支持capa时忽略错误，主节点会按有盘方式发送
+	_, _ = r.command("REPLCONF", "capa", "eof", "capa", "psync2")
+
+	reply, err := r.command("PSYNC", "?", "-1")
+	if err != nil {
+		// Redis 2.8 以前没有PSYNC
+		if err := r.send("SYNC"); err != nil {
+			return fmt.Errorf("SYNC失败: %v", err)
+		}
+		return nil
+	}
+	if !strings.HasPrefix(reply, "+FULLRESYNC") {
+		return fmt.Errorf("PSYNC回复异常: %s", reply)
+	}
+	return nil
+}
+
+// payloadReader 读取全量同步的RDB负载头，返回负载内容的Reader，支持 $<len> 和 $EOF:<mark> 两种格式
+// 有盘格式返回负载长度，无盘格式长度未知返回-1
+func (r *replicaConn) payloadReader() (io.Reader, int64, error) {
+	header, err := r.readLine()
+	if err != nil {
+		return nil, 0, fmt.Errorf("读取RDB负载头失败: %v", err)
+	}
+	if strings.HasPrefix(header, "-") {
+		return nil, 0, errors.New(header[1:])
+	}
+	if !strings.HasPrefix(header, "$") {
+		return nil, 0, fmt.Errorf("RDB负载头格式错误: %q", header)
+	}
+	// 大文件传输时间不可预估，负载期间改为每次读取重新计算超时，连接停滞时不会一直阻塞
+	payload := &deadlineReader{conn: r.conn, src: r.reader}
+	if strings.HasPrefix(header, "$EOF:") {
+		mark := []byte(header[5:])
+		if len(mark) != eofMarkLen {
+			return nil, 0, fmt.Errorf("EOF标记长度错误: %d", len(mark))
+		}
+		return newEOFMarkReader(payload, mark), -1, nil
+	}
+	size, err := strconv.ParseInt(header[1:], 10, 64)
+	if err != nil || size < 0 {
+		return nil, 0, fmt.Errorf("RDB负载长度错误: %q", header)
+	}
+	return io.LimitReader(payload, size), size, nil
+}
+
+// deadlineReader 每次读取前设置读超时，超时只限制单次读取的等待时间
+type deadlineReader struct {
+	conn net.Conn
+	src  io.Reader
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	_ = r.conn.SetReadDeadline(time.Now().Add(replReadTimeout))
+	return r.src.Read(p)
+}
+
+// readPayload 读取全量同步的RDB负载并写入w
+func (r *replicaConn) readPayload(w io.Writer) (int64, error) {
+	payload, size, err := r.payloadReader()
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(w, payload)
+	if err != nil {
+		return n, fmt.Errorf("读取RDB负载失败(%d字节): %v", n, err)
+	}
+	if size >= 0 && n != size {
+		return n, fmt.Errorf("读取RDB负载失败(%d/%d字节): %v", n, size, io.ErrUnexpectedEOF)
+	}
+	return n, nil
+}
+
+// eofMarkReader 读取无盘复制的负载，遇到EOF标记时返回io.EOF，标记本身及之后的复制流不返回
+type eofMarkReader struct {
+	src   io.Reader
+	mark  []byte
+	chunk []byte
+	buf   []byte // 已读取未返回的数据，未结束时末尾len(mark)字节可能是标记
+	done  bool
+}
+
+func newEOFMarkReader(src io.Reader, mark []byte) *eofMarkReader {
+	return &eofMarkReader{
+		src:   src,
+		mark:  mark,
+		chunk: make([]byte, 64*1024+len(mark)),
+	}
+}
+
+func (r *eofMarkReader) Read(p []byte) (int, error) {
+	for {
+		avail := len(r.buf)
+		if !r.done {
+			avail -= len(r.mark)
+		}
+		if avail > 0 {
+			n := copy(p, r.buf[:avail])
+			r.buf = r.buf[n:]
+			return n, nil
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		// 把保留的字节移到开头后继续读取
+		pending := copy(r.chunk, r.buf)
+		n, err := r.src.Read(r.chunk[pending:])
+		r.buf = r.chunk[:pending+n]
+		// 标记之后主节点会继续发送复制流(PING、REPLCONF GETACK等)，可能与标记在同一次读取中返回
+		if i := bytes.Index(r.buf, r.mark); i >= 0 {
+			r.buf = r.buf[:i]
+			r.done = true
+			continue
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+}
+
+// fetchRDB 以从节点身份连接addr，通过复制协议拉取RDB并写入w，返回写入的字节数
+func (rc *RedisConnection) fetchRDB(addr string, w io.Writer) (int64, error) {
+	r, err := rc.dialReplica(addr)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	if err = r.handshake(); err != nil {
+		return 0, err
+	}
+	return r.readPayload(w)
+}
+
+// rdbStream 复制连接上的RDB负载流，关闭时同时关闭连接和落盘文件
+type rdbStream struct {
+	io.Reader
+	conn    *replicaConn
+	tee     *os.File
+	teePath string // 负载完整读取后，临时文件重命名为该路径
+}
+
+// Close 落盘时先读完剩余的负载，完整的RDB才重命名为teePath，否则删除临时文件，不会覆盖之前保存的文件
+func (s *rdbStream) Close() error {
+	var err error
+	if s.tee != nil {
+		_, err = io.Copy(io.Discard, s.Reader)
+		if closeErr := s.tee.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(s.tee.Name(), s.teePath)
+		}
+		if err != nil {
+			_ = os.Remove(s.tee.Name())
+			err = fmt.Errorf("保存RDB文件 %s 失败: %v", s.teePath, err)
+		}
+	}
+	if connErr := s.conn.Close(); err == nil {
+		err = connErr
+	}
+	return err
+}
+
+// streamRDB 以从节点身份连接addr，返回RDB负载流，不落盘直接交给解码器
+// teePath不为空时，读取的同时将RDB写入临时文件，关闭时负载完整才重命名为teePath
+func (rc *RedisConnection) streamRDB(addr string, teePath string) (io.ReadCloser, error) {
+	r, err := rc.dialReplica(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.handshake(); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	payload, _, err := r.payloadReader()
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	stream := &rdbStream{Reader: payload, conn: r}
+	if teePath != "" {
+		// 每次打开都是一次新的全量同步，先写入临时文件，避免覆盖之前保存的文件
+		stream.teePath = teePath
+		stream.tee, err = os.CreateTemp(filepath.Dir(teePath), filepath.Base(teePath)+".*.tmp")
+		if err != nil {
+			_ = r.Close()
+			return nil, fmt.Errorf("创建RDB文件失败: %v", err)
+		}
+		stream.Reader = io.TeeReader(payload, stream.tee)
+	}
+	return stream, nil
+}
@@ -0,0 +1,207 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMaster 接受一个连接，应答复制握手后发送payload
+func fakeMaster(t *testing.T, password string, payload func(w io.Writer)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			args, err := readCmd(reader)
+			if err != nil {
+				return
+			}
+			switch strings.ToUpper(args[0]) {
+			case "AUTH":
+				if args[1] != password {
+					_, _ = conn.Write([]byte("-WRONGPASS invalid password\r\n"))
+					continue
+				}
+				_, _ = conn.Write([]byte("+OK\r\n"))
+			case "PING":
+				_, _ = conn.Write([]byte("+PONG\r\n"))
+			case "REPLCONF":
+				_, _ = conn.Write([]byte("+OK\r\n"))
+			case "PSYNC":
+				_, _ = conn.Write([]byte("+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\r\n\n\n"))
+				payload(conn)
+				return
+			}
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func readCmd(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	var n int
+	if _, err = fmt.Sscanf(line, "*%d\r\n", &n); err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if _, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, strings.TrimRight(arg, "\r\n"))
+	}
+	return args, nil
+}
+
+func TestFetchRDB(t *testing.T) {
+	rdb := []byte("REDIS0009\xfa\x09redis-ver\x057.0.0\xff")
+	addr := fakeMaster(t, "secret", func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "$%d\r\n", len(rdb))
+		_, _ = w.Write(rdb)
+	})
+	rc := &RedisConnection{Password: "secret"}
+	buf := &bytes.Buffer{}
+	n, err := rc.fetchRDB(addr, buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if n != int64(len(rdb)) || !bytes.Equal(buf.Bytes(), rdb) {
+		t.Error("wrong rdb payload")
+	}
+}
+
+func TestFetchRDBDiskless(t *testing.T) {
+	rdb := bytes.Repeat([]byte("0123456789"), 10000)
+	mark := strings.Repeat("a", eofMarkLen)
+	addr := fakeMaster(t, "", func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "$EOF:%s\r\n", mark)
+		_, _ = w.Write(rdb)
+		_, _ = w.Write([]byte(mark))
+	})
+	rc := &RedisConnection{}
+	buf := &bytes.Buffer{}
+	n, err := rc.fetchRDB(addr, buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if n != int64(len(rdb)) || !bytes.Equal(buf.Bytes(), rdb) {
+		t.Error("wrong diskless rdb payload")
+	}
+}
+
+func TestFetchRDBWrongPassword(t *testing.T) {
+	addr := fakeMaster(t, "secret", func(w io.Writer) {})
+	rc := &RedisConnection{Password: "wrong"}
+	_, err := rc.fetchRDB(addr, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("expect auth error, got %v", err)
+	}
+}
+
+func TestEOFMarkReaderTruncated(t *testing.T) {
+	mark := []byte(strings.Repeat("m", eofMarkLen))
+	_, err := io.Copy(io.Discard, newEOFMarkReader(strings.NewReader("no mark here"), mark))
+	if err != io.ErrUnexpectedEOF {
+		t.Error("expect error when mark is missing")
+	}
+}
+
+func TestStreamRDB(t *testing.T) {
+	rdb := bytes.Repeat([]byte("stream"), 30000)
+	mark := strings.Repeat("b", eofMarkLen)
+	addr := fakeMaster(t, "", func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "$EOF:%s\r\n", mark)
+		_, _ = w.Write(rdb)
+		_, _ = w.Write([]byte(mark))
+	})
+	teePath := filepath.Join(t.TempDir(), "tee.rdb")
+	rc := &RedisConnection{}
+	stream, err := rc.streamRDB(addr, teePath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = stream.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(data, rdb) {
+		t.Error("wrong streamed rdb payload")
+	}
+	teeData, err := os.ReadFile(teePath)
+	if err != nil || !bytes.Equal(teeData, rdb) {
+		t.Error("wrong tee file")
+	}
+
+	// 负载不完整时删除临时文件，之前保存的文件不受影响
+	addr = fakeMaster(t, "", func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "$EOF:%s\r\ntruncated", mark)
+	})
+	if stream, err = rc.streamRDB(addr, teePath); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = stream.Close(); err == nil {
+		t.Error("expect error for truncated payload")
+	}
+	if teeData, _ = os.ReadFile(teePath); !bytes.Equal(teeData, rdb) {
+		t.Error("tee file should not be overwritten by a failed stream")
+	}
+	if matches, _ := filepath.Glob(teePath + ".*.tmp"); len(matches) != 0 {
+		t.Errorf("temp files not removed: %v", matches)
+	}
+}
+
+func TestEOFMarkReaderTrailing(t *testing.T) {
+	mark := strings.Repeat("m", eofMarkLen)
+	// 标记之后紧跟复制流，同一次读取返回
+	data, err := io.ReadAll(newEOFMarkReader(strings.NewReader("payload"+mark+"*1\r\n$4\r\nPING\r\n"), []byte(mark)))
+	if err != nil || string(data) != "payload" {
+		t.Errorf("wrong payload: %q, %v", data, err)
+	}
+}
+
+func TestFetchRDBStalled(t *testing.T) {
+	timeout := replReadTimeout
+	replReadTimeout = 100 * time.Millisecond
+	defer func() { replReadTimeout = timeout }()
+	addr := fakeMaster(t, "", func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "$EOF:%s\r\npartial", strings.Repeat("s", eofMarkLen))
+		// 负载传输中途停滞
+		time.Sleep(time.Second)
+	})
+	rc := &RedisConnection{}
+	if _, err := rc.fetchRDB(addr, io.Discard); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("expect timeout, got %v", err)
+	}
+}
@@ -16,6 +16,14 @@ type CmdLine = [][]byte
 // lexOrder traversal map in lex order to create
 type lexOrder struct{}
 
+// CmdSizeOption limits the payload bytes of a single command, big collections are split into several commands
+type CmdSizeOption int
+
+// WithCmdSizeLimit splits commands of big collections so that no command exceeds limit bytes
+func WithCmdSizeLimit(limit int) CmdSizeOption {
+	return CmdSizeOption(limit)
+}
+
 func makeMultiBulkResp(args [][]byte) []byte {
 	argLen := len(args)
 	var buf bytes.Buffer
@@ -110,6 +118,126 @@ func zSetToCmd(obj *model.ZSetObject) CmdLine {
 	return cmdLine
 }
 
+var hSetCmd = []byte("HSET")
+
+// splitCmd splits a collection command into several commands with the same command name and key,
+// each carrying whole elements (step args per element) and at most limit bytes of arguments.
+// An element bigger than limit still goes into its own command.
+func splitCmd(cmdLine CmdLine, step int, limit int) []CmdLine {
+	header := cmdLine[:2]
+	headerSize := len(header[0]) + len(header[1])
+	var cmdLines []CmdLine
+	var chunk CmdLine
+	size := headerSize
+	for i := 2; i+step <= len(cmdLine); i += step {
+		elemSize := 0
+		for _, arg := range cmdLine[i : i+step] {
+			elemSize += len(arg)
+		}
+		if len(chunk) > 0 && size+elemSize > limit {
+			cmdLines = append(cmdLines, append(CmdLine{header[0], header[1]}, chunk...))
+			chunk, size = nil, headerSize
+		}
+		chunk = append(chunk, cmdLine[i:i+step]...)
+		size += elemSize
+	}
+	if len(chunk) > 0 {
+		cmdLines = append(cmdLines, append(CmdLine{header[0], header[1]}, chunk...))
+	}
+	return cmdLines
+}
+
+var (
+	xAddCmd     = []byte("XADD")
+	xSetIdCmd   = []byte("XSETID")
+	xGroupCmd   = []byte("XGROUP")
+	xClaimCmd   = []byte("XCLAIM")
+	maxLenArg   = []byte("MAXLEN")
+	createArg   = []byte("CREATE")
+	consumerArg = []byte("CREATECONSUMER")
+)
+
+func streamIdBytes(id *model.StreamId) []byte {
+	text, _ := id.MarshalText()
+	return text
+}
+
+// streamToCmd converts stream like redis aof rewrite does: XADD for every message,
+// XSETID to restore last id, XGROUP CREATE for groups and XCLAIM to rebuild pending entries
+func streamToCmd(obj *model.StreamObject) []CmdLine {
+	key := []byte(obj.GetKey())
+	cmdLines := make([]CmdLine, 0)
+	for _, entry := range obj.Entries {
+		for _, msg := range entry.Msgs {
+			if msg.Deleted {
+				continue
+			}
+			// 字段顺序在消息中已丢失，按字段名排序保证输出稳定
+			fields := make([]string, 0, len(msg.Fields))
+			for field := range msg.Fields {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			cmdLine := CmdLine{xAddCmd, key, streamIdBytes(msg.Id)}
+			for _, field := range fields {
+				cmdLine = append(cmdLine, []byte(field), []byte(msg.Fields[field]))
+			}
+			cmdLines = append(cmdLines, cmdLine)
+		}
+	}
+	lastId := obj.LastId
+	if lastId == nil {
+		lastId = &model.StreamId{}
+	}
+	if len(cmdLines) == 0 {
+		// 空的stream无法直接创建，与AOF重写相同，用ID 0-1添加一条再用MAXLEN 0裁剪掉，之后由XSETID恢复最后的ID
+		// 不能使用lastId，MKSTREAM创建的stream的lastId为0-0，XADD会拒绝
+		cmdLines = append(cmdLines, CmdLine{xAddCmd, key, maxLenArg, []byte("0"), []byte("0-1"), []byte("x"), []byte("y")})
+	}
+	setId := CmdLine{xSetIdCmd, key, streamIdBytes(lastId)}
+	if obj.Version >= 2 {
+		setId = append(setId, []byte("ENTRIESADDED"), []byte(strconv.FormatUint(obj.AddedEntriesCount, 10)))
+		if obj.MaxDeletedId != nil {
+			setId = append(setId, []byte("MAXDELETEDID"), streamIdBytes(obj.MaxDeletedId))
+		}
+	}
+	cmdLines = append(cmdLines, setId)
+	for _, group := range obj.Groups {
+		groupName := []byte(group.Name)
+		groupLastId := group.LastId
+		if groupLastId == nil {
+			groupLastId = &model.StreamId{}
+		}
+		create := CmdLine{xGroupCmd, createArg, key, groupName, streamIdBytes(groupLastId)}
+		if obj.Version >= 2 {
+			create = append(create, []byte("ENTRIESREAD"), []byte(strconv.FormatUint(group.EntriesRead, 10)))
+		}
+		cmdLines = append(cmdLines, create)
+		nacks := make(map[model.StreamId]*model.StreamNAck, len(group.Pending))
+		for _, nack := range group.Pending {
+			nacks[*nack.Id] = nack
+		}
+		for _, consumer := range group.Consumers {
+			consumerName := []byte(consumer.Name)
+			if len(consumer.Pending) == 0 {
+				cmdLines = append(cmdLines, CmdLine{xGroupCmd, consumerArg, key, groupName, consumerName})
+				continue
+			}
+			for _, id := range consumer.Pending {
+				claim := CmdLine{xClaimCmd, key, groupName, consumerName, []byte("0"), streamIdBytes(id)}
+				if nack, ok := nacks[*id]; ok {
+					claim = append(claim,
+						[]byte("TIME"), []byte(strconv.FormatUint(nack.DeliveryTime, 10)),
+						[]byte("RETRYCOUNT"), []byte(strconv.FormatUint(nack.DeliveryCount, 10)))
+				}
+				claim = append(claim, []byte("JUSTID"), []byte("FORCE"))
+				cmdLines = append(cmdLines, claim)
+			}
+		}
+	}
+	return cmdLines
+}
+
 var pExpireAtBytes = []byte("PEXPIREAT")
 
 // MakeExpireCmd generates command line to set expiration for the given key
@@ -131,10 +259,13 @@ func ObjectToCmd(obj model.RedisObject, opts ...interface{}) []CmdLine {
 		return nil
 	}
 	useLexOrder := false
+	sizeLimit := 0
 	for _, o := range opts {
-		switch o.(type) {
+		switch o := o.(type) {
 		case lexOrder:
 			useLexOrder = true
+		case CmdSizeOption:
+			sizeLimit = int(o)
 		}
 	}
 	cmdLines := make([]CmdLine, 0)
@@ -154,6 +285,24 @@ func ObjectToCmd(obj model.RedisObject, opts ...interface{}) []CmdLine {
 	case model.ZSetType:
 		zsetObj := obj.(*model.ZSetObject)
 		cmdLines = append(cmdLines, zSetToCmd(zsetObj))
+	case model.StreamType:
+		streamObj := obj.(*model.StreamObject)
+		cmdLines = append(cmdLines, streamToCmd(streamObj)...)
+	default:
+		// module types carry no raw payload after decoding, so they can not be restored
+		return nil
+	}
+	if sizeLimit > 0 && len(cmdLines) == 1 && obj.GetType() != model.StringType && obj.GetType() != model.StreamType {
+		step := 1
+		switch obj.GetType() {
+		case model.HashType:
+			// HMSET is deprecated, chunks use HSET which accepts multiple fields since redis 4.0
+			cmdLines[0][0] = hSetCmd
+			step = 2
+		case model.ZSetType:
+			step = 2
+		}
+		cmdLines = splitCmd(cmdLines[0], step, sizeLimit)
 	}
 	if obj.GetExpiration() != nil {
 		cmdLines = append(cmdLines, makeExpireCmd(obj))
@@ -0,0 +1,98 @@
+package helper
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hdt3213/rdb/model"
+)
+
+func TestObjectToCmdSizeLimit(t *testing.T) {
+	hash := &model.HashObject{
+		BaseObject: &model.BaseObject{Key: "h"},
+		Hash: map[string][]byte{
+			"f1": []byte("aaaa"),
+			"f2": []byte("bbbb"),
+			"f3": []byte(strings.Repeat("c", 32)),
+		},
+	}
+	// 命令名和KEY占5字节，每个field+value占6字节
+	cmdLines := ObjectToCmd(hash, lexOrder{}, WithCmdSizeLimit(20))
+	if len(cmdLines) != 2 {
+		t.Errorf("expect 2 commands, got %d", len(cmdLines))
+		return
+	}
+	if string(bytes.Join(cmdLines[0], []byte(" "))) != "HSET h f1 aaaa f2 bbbb" {
+		t.Errorf("wrong first chunk: %q", cmdLines[0])
+	}
+	// 超过限制的单个元素独占一条命令
+	if len(cmdLines[1]) != 4 || string(cmdLines[1][2]) != "f3" {
+		t.Errorf("wrong second chunk: %q", cmdLines[1])
+	}
+
+	list := &model.ListObject{
+		BaseObject: &model.BaseObject{Key: "l"},
+		Values:     [][]byte{[]byte("1"), []byte("2"), []byte("3")},
+	}
+	if cmdLines := ObjectToCmd(list, WithCmdSizeLimit(7)); len(cmdLines) != 3 {
+		t.Errorf("expect 3 RPUSH commands, got %q", cmdLines)
+	}
+	if cmdLines := ObjectToCmd(list); len(cmdLines) != 1 || len(cmdLines[0]) != 5 {
+		t.Errorf("expect one RPUSH command without limit, got %q", cmdLines)
+	}
+}
+
+func TestStreamToCmd(t *testing.T) {
+	id := &model.StreamId{Ms: 100, Sequence: 1}
+	stream := &model.StreamObject{
+		BaseObject: &model.BaseObject{Key: "s"},
+		Version:    2,
+		Entries: []*model.StreamEntry{{Msgs: []*model.StreamMessage{
+			{Id: &model.StreamId{Ms: 100}, Fields: map[string]string{"b": "2", "a": "1"}, Deleted: true},
+			{Id: id, Fields: map[string]string{"b": "2", "a": "1"}},
+		}}},
+		LastId:            id,
+		AddedEntriesCount: 2,
+		Groups: []*model.StreamGroup{{
+			Name:        "g",
+			LastId:      id,
+			EntriesRead: 2,
+			Pending:     []*model.StreamNAck{{Id: id, DeliveryTime: 5, DeliveryCount: 3}},
+			Consumers: []*model.StreamConsumer{
+				{Name: "c1", Pending: []*model.StreamId{id}},
+				{Name: "c2"},
+			},
+		}},
+	}
+	expect := []string{
+		"XADD s 100-1 a 1 b 2",
+		"XSETID s 100-1 ENTRIESADDED 2",
+		"XGROUP CREATE s g 100-1 ENTRIESREAD 2",
+		"XCLAIM s g c1 0 100-1 TIME 5 RETRYCOUNT 3 JUSTID FORCE",
+		"XGROUP CREATECONSUMER s g c2",
+	}
+	cmdLines := ObjectToCmd(stream)
+	if len(cmdLines) != len(expect) {
+		t.Errorf("expect %d commands, got %q", len(expect), cmdLines)
+		return
+	}
+	for i, cmdLine := range cmdLines {
+		if got := string(bytes.Join(cmdLine, []byte(" "))); got != expect[i] {
+			t.Errorf("expect %s, got %s", expect[i], got)
+		}
+	}
+
+	// MKSTREAM创建的空stream，lastId为0-0
+	empty := &model.StreamObject{BaseObject: &model.BaseObject{Key: "e"}, LastId: &model.StreamId{}}
+	cmdLines = ObjectToCmd(empty)
+	if len(cmdLines) != 2 || string(bytes.Join(cmdLines[0], []byte(" "))) != "XADD e MAXLEN 0 0-1 x y" ||
+		string(bytes.Join(cmdLines[1], []byte(" "))) != "XSETID e 0-0" {
+		t.Errorf("wrong commands for empty stream: %q", cmdLines)
+	}
+
+	module := &model.ModuleTypeObject{BaseObject: &model.BaseObject{Key: "m"}, ModuleType: "ReJSON-RL"}
+	if cmdLines := ObjectToCmd(module); cmdLines != nil {
+		t.Errorf("module type should be skipped, got %q", cmdLines)
+	}
+}
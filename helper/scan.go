@@ -8,11 +8,15 @@ import (
 )
 
 type ScanTask struct {
-	RedisServer string
-	Password    string
-	Pattern     string
-	NoCluster   bool
-	Limit       int
+	RedisServer      string
+	Username         string
+	Password         string
+	Pattern          string
+	NoCluster        bool
+	TLS              TLSOptions
+	SentinelUsername string
+	SentinelPassword string
+	Limit            int
 	*RedisConnection
 }
 
@@ -143,9 +147,13 @@ func (s *ScanTask) scanCluster(ctx context.Context, client *redis.ClusterClient)
 func (s *ScanTask) Run() {
 	// 初始化Redis连接配置
 	s.RedisConnection = &RedisConnection{
-		RedisServer: s.RedisServer,
-		Password:    s.Password,
-		NoCluster:   s.NoCluster,
+		RedisServer:      s.RedisServer,
+		Username:         s.Username,
+		Password:         s.Password,
+		NoCluster:        s.NoCluster,
+		TLS:              s.TLS,
+		SentinelUsername: s.SentinelUsername,
+		SentinelPassword: s.SentinelPassword,
 	}
 
 	err := s.scan()
@@ -0,0 +1,107 @@
+package helper
+
+import (
+	"net"
+	"sync"
+)
+
+// nodeHost 返回节点地址中的主机部分，无法解析时返回原地址
+func nodeHost(node string) string {
+	host, _, err := net.SplitHostPort(node)
+	if err != nil {
+		return node
+	}
+	return host
+}
+
+// hostScheduler 有界并发地执行节点任务，同一主机上的节点不会同时执行，
+// 避免一台机器同时承受多个BGSAVE的fork
+type hostScheduler struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	hosts   []string            // 主机轮询顺序
+	pending map[string][]string // 主机 -> 待执行的节点
+	busy    map[string]bool     // 主机 -> 是否有节点正在执行
+	remain  int
+	hostOf  func(node string) string // 节点所在的主机
+}
+
+func newHostScheduler(nodes []string) *hostScheduler {
+	return newHostSchedulerFunc(nodes, nodeHost)
+}
+
+// newHostSchedulerFunc 由hostOf决定任务所在的主机，hostOf返回不同值的任务可以同时执行
+func newHostSchedulerFunc(nodes []string, hostOf func(node string) string) *hostScheduler {
+	s := &hostScheduler{
+		pending: make(map[string][]string),
+		busy:    make(map[string]bool),
+		remain:  len(nodes),
+		hostOf:  hostOf,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	for _, node := range nodes {
+		host := hostOf(node)
+		if _, ok := s.pending[host]; !ok {
+			s.hosts = append(s.hosts, host)
+		}
+		s.pending[host] = append(s.pending[host], node)
+	}
+	return s
+}
+
+// next 取出一个所在主机空闲的节点，没有可执行节点时阻塞，全部分配完毕返回false
+func (s *hostScheduler) next() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if s.remain == 0 {
+			return "", false
+		}
+		for i, host := range s.hosts {
+			if s.busy[host] || len(s.pending[host]) == 0 {
+				continue
+			}
+			node := s.pending[host][0]
+			s.pending[host] = s.pending[host][1:]
+			s.busy[host] = true
+			s.remain--
+			// 刚分配的主机移到队尾，让其他主机优先
+			s.hosts = append(append(s.hosts[:i:i], s.hosts[i+1:]...), host)
+			return node, true
+		}
+		s.cond.Wait()
+	}
+}
+
+// done 标记节点执行完毕，释放其所在主机
+func (s *hostScheduler) done(node string) {
+	s.mu.Lock()
+	s.busy[s.hostOf(node)] = false
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
+
+// run 以parallel个worker执行所有节点任务，返回时所有任务均已结束
+func (s *hostScheduler) run(parallel int, fn func(node string)) {
+	if parallel <= 0 {
+		parallel = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				node, ok := s.next()
+				if !ok {
+					// 唤醒其他等待的worker，让它们也能退出
+					s.cond.Broadcast()
+					return
+				}
+				fn(node)
+				s.done(node)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,54 @@
+package helper
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostScheduler(t *testing.T) {
+	nodes := []string{
+		"10.0.0.1:6379", "10.0.0.1:6380", "10.0.0.1:6381",
+		"10.0.0.2:6379", "10.0.0.2:6380",
+		"10.0.0.3:6379",
+		"10.0.0.4:6379",
+	}
+	var mu sync.Mutex
+	runningHosts := make(map[string]bool)
+	running, maxRunning := 0, 0
+	var finished []string
+	newHostScheduler(nodes).run(3, func(node string) {
+		host := nodeHost(node)
+		mu.Lock()
+		if runningHosts[host] {
+			t.Errorf("host %s is dumping more than one node", host)
+		}
+		runningHosts[host] = true
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		runningHosts[host] = false
+		running--
+		finished = append(finished, node)
+		mu.Unlock()
+	})
+	if maxRunning > 3 {
+		t.Errorf("expect at most 3 workers, got %d", maxRunning)
+	}
+	sort.Strings(finished)
+	if len(finished) != len(nodes) {
+		t.Errorf("expect %d nodes finished, got %d", len(nodes), len(finished))
+		return
+	}
+	for i := range nodes {
+		if finished[i] != nodes[i] {
+			t.Error("wrong finished nodes")
+			return
+		}
+	}
+}
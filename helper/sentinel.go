@@ -0,0 +1,202 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	sentinelScheme    = "redis-sentinel://"
+	sentinelTLSScheme = "rediss-sentinel://" // 哨兵和数据节点都使用TLS连接
+)
+
+// sentinelSrc 解析后的哨兵连接地址
+type sentinelSrc struct {
+	sentinels  []string
+	masterName string
+	db         int
+	username   string
+	password   string
+	useTLS     bool
+}
+
+// IsSentinelSrc 判断数据源是否为哨兵地址
+func IsSentinelSrc(src string) bool {
+	return strings.HasPrefix(src, sentinelScheme) || strings.HasPrefix(src, sentinelTLSScheme)
+}
+
+// parseSentinelSrc 解析 redis-sentinel://[user:pass@]host1:26379,host2:26379/mymaster[/db]
+// 地址中的用户名密码用于连接数据节点，rediss-sentinel:// 时哨兵和数据节点都使用TLS
+func parseSentinelSrc(redisServer string) (sentinelSrc, error) {
+	var src sentinelSrc
+	rest := strings.TrimPrefix(redisServer, sentinelScheme)
+	if strings.HasPrefix(redisServer, sentinelTLSScheme) {
+		rest, src.useTLS = strings.TrimPrefix(redisServer, sentinelTLSScheme), true
+	}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		userInfo := rest[:i]
+		rest = rest[i+1:]
+		if user, pass, ok := strings.Cut(userInfo, ":"); ok {
+			src.username, src.password = user, pass
+		} else {
+			src.password = userInfo
+		}
+	}
+	hosts, path, _ := strings.Cut(rest, "/")
+	for _, host := range strings.Split(hosts, ",") {
+		if host == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "26379")
+		}
+		src.sentinels = append(src.sentinels, host)
+	}
+	if len(src.sentinels) == 0 {
+		return src, fmt.Errorf("invalid sentinel address %s: sentinel host is required", redisServer)
+	}
+	masterName, dbStr, _ := strings.Cut(path, "/")
+	if masterName == "" {
+		return src, fmt.Errorf("invalid sentinel address %s: master name is required", redisServer)
+	}
+	src.masterName = masterName
+	if dbStr != "" {
+		db, err := strconv.Atoi(dbStr)
+		if err != nil {
+			return src, fmt.Errorf("invalid redis db %s: %v", dbStr, err)
+		}
+		src.db = db
+	}
+	return src, nil
+}
+
+// sentinelView 单个哨兵看到的主从拓扑
+type sentinelView struct {
+	sentinel string
+	master   string
+	replicas []ShardNode
+}
+
+func (rc *RedisConnection) querySentinel(ctx context.Context, addr string, masterName string) (*sentinelView, error) {
+	// 与数据节点相同的TLS配置，认证使用哨兵单独的ACL用户名和密码
+	client := redis.NewSentinelClient(&redis.Options{
+		Addr:      addr,
+		Username:  rc.SentinelUsername,
+		Password:  rc.SentinelPassword,
+		TLSConfig: rc.tlsConfig,
+	})
+	defer client.Close()
+	masterAddr, err := client.GetMasterAddrByName(ctx, masterName).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(masterAddr) != 2 {
+		return nil, fmt.Errorf("unexpected master address: %v", masterAddr)
+	}
+	view := &sentinelView{
+		sentinel: addr,
+		master:   net.JoinHostPort(masterAddr[0], masterAddr[1]),
+	}
+	replicas, err := client.Replicas(ctx, masterName).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, replica := range replicas {
+		flags := replica["flags"]
+		view.replicas = append(view.replicas, ShardNode{
+			ID:   replica["runid"],
+			Addr: net.JoinHostPort(replica["ip"], replica["port"]),
+			Healthy: !strings.Contains(flags, "s_down") && !strings.Contains(flags, "o_down") &&
+				!strings.Contains(flags, "disconnected") && replica["master-link-status"] == "ok",
+		})
+	}
+	return view, nil
+}
+
+// resolveSentinel 询问所有哨兵当前的主节点及其从节点，哨兵意见不一致时告警并采用多数派的结果
+func (rc *RedisConnection) resolveSentinel() (*Shard, error) {
+	src, err := parseSentinelSrc(rc.RedisServer)
+	if err != nil {
+		return nil, err
+	}
+	rc.DB = src.db
+	if rc.Username == "" {
+		rc.Username = src.username
+	}
+	if rc.Password == "" {
+		rc.Password = src.password
+	}
+	if src.useTLS {
+		if rc.tlsConfig, err = rc.TLS.config(); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Printf("「连接」- 通过哨兵查询主节点 %s：%s...\n", src.masterName, strings.Join(src.sentinels, ","))
+	ctx := context.Background()
+	votes := make(map[string][]*sentinelView)
+	for _, addr := range src.sentinels {
+		view, err := rc.querySentinel(ctx, addr, src.masterName)
+		if err != nil {
+			fmt.Printf("「连接」- 哨兵 %s 查询失败，忽略: %v\n", addr, err)
+			continue
+		}
+		votes[view.master] = append(votes[view.master], view)
+	}
+	if len(votes) == 0 {
+		return nil, fmt.Errorf("「连接」- 所有哨兵均查询失败，无法获取主节点 %s", src.masterName)
+	}
+
+	masters := make([]string, 0, len(votes))
+	for master := range votes {
+		masters = append(masters, master)
+	}
+	sort.Slice(masters, func(i, j int) bool {
+		if len(votes[masters[i]]) != len(votes[masters[j]]) {
+			return len(votes[masters[i]]) > len(votes[masters[j]])
+		}
+		return masters[i] < masters[j]
+	})
+	if len(masters) > 1 {
+		fmt.Println("「连接」- ⚠️  哨兵对主节点的判断不一致，可能正在故障转移:")
+		for _, master := range masters {
+			var sentinels []string
+			for _, view := range votes[master] {
+				sentinels = append(sentinels, view.sentinel)
+			}
+			fmt.Printf("「连接」-   %s <- %s\n", master, strings.Join(sentinels, ","))
+		}
+		fmt.Printf("「连接」- 采用多数哨兵的结果：%s\n", masters[0])
+	}
+
+	views := votes[masters[0]]
+	view := views[0]
+	for _, other := range views[1:] {
+		if replicaAddrs(other) != replicaAddrs(view) {
+			fmt.Printf("「连接」- ⚠️  哨兵看到的从节点不一致: %s -> [%s], %s -> [%s]，采用 %s 的结果\n",
+				view.sentinel, replicaAddrs(view), other.sentinel, replicaAddrs(other), view.sentinel)
+		}
+	}
+	rc.HostPort = view.master
+	return &Shard{
+		Master:   ShardNode{Addr: view.master, Healthy: true},
+		Replicas: view.replicas,
+		Name:     src.masterName,
+	}, nil
+}
+
+// replicaAddrs 哨兵看到的从节点地址，排序后用于比较
+func replicaAddrs(view *sentinelView) string {
+	addrs := make([]string, 0, len(view.replicas))
+	for _, replica := range view.replicas {
+		addrs = append(addrs, replica.Addr)
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ",")
+}
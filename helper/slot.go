@@ -0,0 +1,377 @@
+package helper
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hdt3213/rdb/bytefmt"
+	"github.com/hdt3213/rdb/model"
+)
+
+// slotCount Redis集群的slot数量
+const slotCount = 16384
+
+// hotSlotRatio slot大小超过所有slot平均大小的倍数时视为热点slot
+var hotSlotRatio = 10
+
+// balanceTolerance 节点大小与平均值的偏差在该比例以内时不再建议迁移
+var balanceTolerance = 0.05
+
+// maxSlotMoves 最多给出的迁移建议数
+var maxSlotMoves = 1000
+
+var crc16Table [256]uint16
+
+func init() {
+	// CRC16-CCITT (XMODEM)，多项式0x1021，与Redis集群一致
+	for i := range crc16Table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// hashTag 返回KEY中用于计算slot的部分，第一个{和之后第一个}之间不为空时只使用其中的内容
+func hashTag(key string) string {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key[start+1 : start+1+end]
+		}
+	}
+	return key
+}
+
+// keyHashSlot 计算KEY所在的slot，与 CLUSTER KEYSLOT 相同
+func keyHashSlot(key string) int {
+	return int(crc16(hashTag(key)) % slotCount)
+}
+
+type slotCounter struct {
+	count int
+	size  int
+}
+
+// slotStats 一个节点每个slot的KEY个数和大小
+type slotStats [slotCount]slotCounter
+
+// slotNode 集群节点或规划的节点，持有的slot和总大小
+type slotNode struct {
+	name  string
+	slots []int
+	count int
+	size  int
+}
+
+// slotMove 一条迁移建议
+type slotMove struct {
+	slot     int
+	from, to *slotNode
+	slotCounter
+	fromAfter, toAfter int
+}
+
+// slotAnalyzer 计算每个KEY的slot，按slot和节点汇总KEY个数和大小，标记热点slot并给出均衡内存的迁移建议
+// 集群导出的RDB每个文件对应一个节点，slot归属于KEY所在的节点；
+// 指定规划的节点数时按 redis-cli --cluster create 的方式平均分配slot，用于评估单机迁移到集群后的分布
+type slotAnalyzer struct {
+	cfg     *AnalyzeConfig
+	planned int
+	mu      sync.Mutex // 保护sources和stats
+	sources []string
+	stats   map[string]*slotStats // 数据源 -> slot统计
+}
+
+func newSlotAnalyzer(cfg *AnalyzeConfig) (Analyzer, error) {
+	if cfg.SlotNodes < 0 {
+		return nil, errors.New("节点数量必须大于0")
+	}
+	return &slotAnalyzer{cfg: cfg, planned: cfg.SlotNodes, stats: make(map[string]*slotStats)}, nil
+}
+
+func (a *slotAnalyzer) Begin(rdbFilename string) (FileAnalyzer, error) {
+	return &slotFileAnalyzer{slotAnalyzer: a, source: sourceLabel(rdbFilename), stats: &slotStats{}}, nil
+}
+
+type slotFileAnalyzer struct {
+	*slotAnalyzer
+	source string
+	stats  *slotStats
+}
+
+func (fa *slotFileAnalyzer) Add(object model.RedisObject) {
+	c := &fa.stats[keyHashSlot(object.GetKey())]
+	c.count++
+	c.size += object.GetSize()
+}
+
+// Finish slot分布需要所有节点的数据，在Close中统一输出
+func (fa *slotFileAnalyzer) Finish() ([]string, error) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	stats := fa.slotAnalyzer.stats[fa.source]
+	if stats == nil {
+		fa.slotAnalyzer.stats[fa.source] = fa.stats
+		fa.sources = append(fa.sources, fa.source)
+		return nil, nil
+	}
+	for i := range stats {
+		stats[i].count += fa.stats[i].count
+		stats[i].size += fa.stats[i].size
+	}
+	return nil, nil
+}
+
+// slotOwners 返回每个slot所属的节点，集群中slot归属于该slot数据最多的数据源，规划节点时平均分配
+func (a *slotAnalyzer) slotOwners(total *slotStats) ([]*slotNode, [slotCount]*slotNode) {
+	var owners [slotCount]*slotNode
+	var nodes []*slotNode
+	if a.planned > 0 {
+		for i := 0; i < a.planned; i++ {
+			start, end := i*slotCount/a.planned, (i+1)*slotCount/a.planned-1
+			node := &slotNode{name: fmt.Sprintf("node-%d(%d-%d)", i+1, start, end)}
+			for slot := start; slot <= end; slot++ {
+				owners[slot] = node
+			}
+			nodes = append(nodes, node)
+		}
+	} else {
+		sources := append([]string(nil), a.sources...)
+		sort.Strings(sources)
+		byName := make(map[string]*slotNode)
+		for _, source := range sources {
+			node := &slotNode{name: source}
+			byName[source] = node
+			nodes = append(nodes, node)
+		}
+		for slot := range owners {
+			best := ""
+			for _, source := range sources {
+				if c := a.stats[source][slot]; c.count > 0 && (best == "" || c.size > a.stats[best][slot].size) {
+					best = source
+				}
+			}
+			if best != "" {
+				owners[slot] = byName[best]
+			}
+		}
+	}
+	for slot, node := range owners {
+		if node != nil && total[slot].count > 0 {
+			node.slots = append(node.slots, slot)
+			node.count += total[slot].count
+			node.size += total[slot].size
+		}
+	}
+	return nodes, owners
+}
+
+// suggestMoves 每次把最大节点中最合适的slot迁移到最小节点，直到所有节点与平均值的偏差在容忍范围内
+// 选择不超过两节点差值一半的最大slot，迁移后两个节点都更接近平均值
+func suggestMoves(nodes []*slotNode, total *slotStats) []*slotMove {
+	if len(nodes) < 2 {
+		return nil
+	}
+	sum := 0
+	for _, node := range nodes {
+		sum += node.size
+	}
+	tolerance := int(float64(sum) / float64(len(nodes)) * balanceTolerance)
+	var moves []*slotMove
+	for len(moves) < maxSlotMoves {
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return nodes[i].size > nodes[j].size
+		})
+		from, to := nodes[0], nodes[len(nodes)-1]
+		gap := from.size - to.size
+		if gap <= 2*tolerance {
+			break
+		}
+		best := -1
+		for i, slot := range from.slots {
+			size := total[slot].size
+			if size > 0 && size <= gap/2 && (best < 0 || size > total[from.slots[best]].size) {
+				best = i
+			}
+		}
+		if best < 0 {
+			break // 剩下的slot都太大，迁移后反而更不均衡，需要拆分热点KEY
+		}
+		slot := from.slots[best]
+		c := total[slot]
+		from.slots = append(from.slots[:best], from.slots[best+1:]...)
+		to.slots = append(to.slots, slot)
+		from.count -= c.count
+		from.size -= c.size
+		to.count += c.count
+		to.size += c.size
+		moves = append(moves, &slotMove{slot: slot, from: from, to: to, slotCounter: c, fromAfter: from.size, toAfter: to.size})
+	}
+	return moves
+}
+
+func (a *slotAnalyzer) Close() ([]string, error) {
+	if len(a.sources) == 0 {
+		return nil, nil
+	}
+	total := &slotStats{}
+	for _, stats := range a.stats {
+		for i := range stats {
+			total[i].count += stats[i].count
+			total[i].size += stats[i].size
+		}
+	}
+	nodes, owners := a.slotOwners(total)
+
+	var outputFiles []string
+	write := func(suffix string, fn func(w *csv.Writer) error) error {
+		outputPath, outputFile, err := a.cfg.CreateOutput("all", suffix)
+		if err != nil {
+			return fmt.Errorf("创建输出文件失败: %v", err)
+		}
+		defer func() {
+			_ = outputFile.Close()
+		}()
+		outputFiles = append(outputFiles, outputPath)
+		csvWriter := csv.NewWriter(outputFile)
+		if err = fn(csvWriter); err != nil {
+			return err
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	}
+	if err := write("-slot.csv", func(w *csv.Writer) error {
+		return writeSlots(w, total, owners)
+	}); err != nil {
+		return outputFiles, err
+	}
+	if err := write("-slot-node.csv", func(w *csv.Writer) error {
+		return writeSlotNodes(w, nodes)
+	}); err != nil {
+		return outputFiles, err
+	}
+	// 节点统计输出后再计算迁移建议，迁移会修改节点持有的slot
+	moves := suggestMoves(nodes, total)
+	err := write("-slot-move.csv", func(w *csv.Writer) error {
+		return writeSlotMoves(w, moves)
+	})
+	return outputFiles, err
+}
+
+// writeSlots 按大小从大到小输出有KEY的slot，大小超过平均值hotSlotRatio倍的标记为热点
+func writeSlots(w *csv.Writer, total *slotStats, owners [slotCount]*slotNode) error {
+	sum := 0
+	var slots []int
+	for slot, c := range total {
+		sum += c.size
+		if c.count > 0 {
+			slots = append(slots, slot)
+		}
+	}
+	sort.SliceStable(slots, func(i, j int) bool {
+		return total[slots[i]].size > total[slots[j]].size
+	})
+	avg := float64(sum) / slotCount
+	if err := w.Write([]string{"slot", "节点", "KEY个数", "KEY大小", "KEY大小[K/M/G]", "平均值倍数", "热点"}); err != nil {
+		return err
+	}
+	for _, slot := range slots {
+		c := total[slot]
+		node, hot := "", ""
+		if owners[slot] != nil {
+			node = owners[slot].name
+		}
+		ratio := 0.0
+		if sum > 0 {
+			ratio = float64(c.size) / avg
+		}
+		if ratio > float64(hotSlotRatio) {
+			hot = "是"
+		}
+		err := w.Write([]string{
+			strconv.Itoa(slot),
+			node,
+			strconv.Itoa(c.count),
+			strconv.Itoa(c.size),
+			bytefmt.FormatSize(uint64(c.size)),
+			strconv.FormatFloat(ratio, 'f', 1, 64),
+			hot,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSlotNodes(w *csv.Writer, nodes []*slotNode) error {
+	sum := 0
+	for _, node := range nodes {
+		sum += node.size
+	}
+	avg := float64(sum) / float64(len(nodes))
+	if err := w.Write([]string{"节点", "有KEY的slot个数", "KEY个数", "KEY大小", "KEY大小[K/M/G]", "内存占比", "与平均值偏差"}); err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		share, deviation := 0.0, 0.0
+		if sum > 0 {
+			share = float64(node.size) * 100 / float64(sum)
+			deviation = (float64(node.size) - avg) * 100 / avg
+		}
+		err := w.Write([]string{
+			node.name,
+			strconv.Itoa(len(node.slots)),
+			strconv.Itoa(node.count),
+			strconv.Itoa(node.size),
+			bytefmt.FormatSize(uint64(node.size)),
+			strconv.FormatFloat(share, 'f', 1, 64) + "%",
+			strconv.FormatFloat(deviation, 'f', 1, 64) + "%",
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSlotMoves(w *csv.Writer, moves []*slotMove) error {
+	if err := w.Write([]string{"序号", "slot", "源节点", "目标节点", "KEY个数", "KEY大小", "KEY大小[K/M/G]", "迁移后源节点大小", "迁移后目标节点大小"}); err != nil {
+		return err
+	}
+	for i, m := range moves {
+		err := w.Write([]string{
+			strconv.Itoa(i + 1),
+			strconv.Itoa(m.slot),
+			m.from.name,
+			m.to.name,
+			strconv.Itoa(m.count),
+			strconv.Itoa(m.size),
+			bytefmt.FormatSize(uint64(m.size)),
+			strconv.Itoa(m.fromAfter),
+			strconv.Itoa(m.toAfter),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package helper
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyHashSlot(t *testing.T) {
+	cases := map[string]int{
+		"foo":       12182,
+		"123456789": 12739,
+		"":          0,
+	}
+	for key, slot := range cases {
+		if got := keyHashSlot(key); got != slot {
+			t.Errorf("slot of %q: expect %d, got %d", key, slot, got)
+		}
+	}
+	tags := map[string]string{
+		"{user1000}.following": "user1000",
+		"foo{}{bar}":           "foo{}{bar}",
+		"foo{{bar}}zap":        "{bar",
+		"foo{bar}{zap}":        "bar",
+		"foo{bar":              "foo{bar",
+	}
+	for key, tag := range tags {
+		if got := hashTag(key); got != tag {
+			t.Errorf("hash tag of %q: expect %q, got %q", key, tag, got)
+		}
+	}
+	if keyHashSlot("{user1000}.following") != keyHashSlot("{user1000}.followers") {
+		t.Error("keys with the same hash tag should be in the same slot")
+	}
+}
+
+func TestSuggestMoves(t *testing.T) {
+	total := &slotStats{}
+	heavy := &slotNode{name: "a"}
+	for slot, size := range []int{100, 60, 30, 10} {
+		total[slot] = slotCounter{count: 1, size: size}
+		heavy.slots = append(heavy.slots, slot)
+		heavy.count++
+		heavy.size += size
+	}
+	light := &slotNode{name: "b"}
+	moves := suggestMoves([]*slotNode{heavy, light}, total)
+	// 200 -> 差值一半为100，先迁移100，之后两个节点相同
+	if len(moves) != 1 || moves[0].slot != 0 || moves[0].fromAfter != 100 || moves[0].toAfter != 100 {
+		t.Errorf("wrong moves: %+v", moves)
+	}
+
+	// 单个slot过大时无法均衡
+	total = &slotStats{}
+	total[0] = slotCounter{count: 1, size: 1000}
+	moves = suggestMoves([]*slotNode{{name: "a", slots: []int{0}, count: 1, size: 1000}, {name: "b"}}, total)
+	if len(moves) != 0 {
+		t.Errorf("hot slot should not be moved: %+v", moves)
+	}
+}
+
+func TestSlotAnalyse(t *testing.T) {
+	dir := t.TempDir()
+	src := writeTestAof(t, dir, "node.aof",
+		[]string{"SET", "foo", "1"},
+		[]string{"SET", "{foo}:a", strings.Repeat("x", 1000)},
+		[]string{"SET", "bar", "1"},
+	)
+	workDir := filepath.Join(dir, "report")
+	_ = os.MkdirAll(workDir, os.ModePerm)
+	cfg := AnalyzeConfig{WorkDir: workDir, WorkDirName: "report", SlotNodes: 2}
+	a, err := newSlotAnalyzer(&cfg)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, _, err = analyseFile(src, []Analyzer{a}); err != nil {
+		t.Error(err)
+		return
+	}
+	files, err := a.Close()
+	if err != nil || len(files) != 3 {
+		t.Errorf("wrong output: %v, %v", files, err)
+		return
+	}
+	read := func(path string) []string {
+		content, _ := os.ReadFile(path)
+		return strings.Split(strings.TrimSpace(string(content)), "\n")
+	}
+	// foo和{foo}:a在slot 12182，属于第二个节点；bar在slot 5061，属于第一个节点
+	slots := read(files[0])
+	if len(slots) != 3 || !strings.HasPrefix(slots[1], "12182,node-2(8192-16383),2,") ||
+		!strings.HasSuffix(slots[1], ",是") || !strings.HasPrefix(slots[2], "5061,node-1(0-8191),1,") {
+		t.Errorf("wrong slots: %v", slots)
+	}
+	if nodes := read(files[1]); len(nodes) != 3 || !strings.HasPrefix(nodes[2], "node-2(8192-16383),1,2,") {
+		t.Errorf("wrong nodes: %v", nodes)
+	}
+	// 唯一的slot迁移后更不均衡，不给出建议
+	if moves := read(files[2]); len(moves) != 1 {
+		t.Errorf("wrong moves: %v", moves)
+	}
+
+	// 所有KEY大小为0时平均值倍数为0，不输出NaN
+	var buf strings.Builder
+	w := csv.NewWriter(&buf)
+	empty := &slotStats{}
+	empty[1] = slotCounter{count: 1}
+	if err = writeSlots(w, empty, [slotCount]*slotNode{}); err != nil {
+		t.Error(err)
+	}
+	w.Flush()
+	if !strings.Contains(buf.String(), "1,,1,0,0,0.0,\n") {
+		t.Errorf("wrong slots of empty keys: %q", buf.String())
+	}
+
+	cfg.SlotNodes = -1
+	if _, err := newSlotAnalyzer(&cfg); err == nil {
+		t.Error("expect error for negative node count")
+	}
+}
@@ -0,0 +1,262 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// streamSource 流式数据源的节点地址和打开方式
+type streamSource struct {
+	node string
+	open func() (io.ReadCloser, error)
+}
+
+// streamSources 流式数据源，以虚拟的RDB文件路径为key，打开时才从Redis节点拉取
+var streamSources = struct {
+	sync.Mutex
+	openers map[string]streamSource
+}{openers: make(map[string]streamSource)}
+
+// registerStreamSource 注册流式数据源，之后openRdb(name)会从open返回的流中读取RDB
+func registerStreamSource(name string, node string, open func() (io.ReadCloser, error)) {
+	streamSources.Lock()
+	defer streamSources.Unlock()
+	streamSources.openers[name] = streamSource{node: node, open: open}
+}
+
+// streamNode 返回流式数据源所在的节点，不是流式数据源时返回false
+func streamNode(name string) (string, bool) {
+	streamSources.Lock()
+	defer streamSources.Unlock()
+	source, ok := streamSources.openers[name]
+	return source.node, ok
+}
+
+// sourceInstances 从Redis导出的数据源(RDB文件或流式数据源)所属分片的稳定标识
+var sourceInstances = struct {
+	sync.Mutex
+	names map[string]string
+}{names: make(map[string]string)}
+
+// registerSourceInstance 记录从Redis导出的数据源所属的分片，趋势数据以分片标识区分实例
+func registerSourceInstance(src string, name string) {
+	sourceInstances.Lock()
+	defer sourceInstances.Unlock()
+	sourceInstances.names[src] = name
+}
+
+// sourceInstance 返回数据源所属分片的稳定标识，不是从Redis导出的数据源时返回false
+func sourceInstance(src string) (string, bool) {
+	sourceInstances.Lock()
+	defer sourceInstances.Unlock()
+	name, ok := sourceInstances.names[src]
+	return name, ok
+}
+
+// openRdb 打开RDB数据源，优先使用已注册的流式数据源，其次是标准输入，否则按本地文件打开
+// 压缩的数据源会根据魔数透明解压
+func openRdb(rdbFilename string) (io.ReadCloser, error) {
+	streamSources.Lock()
+	source, ok := streamSources.openers[rdbFilename]
+	streamSources.Unlock()
+	if ok {
+		rdbStream, err := source.open()
+		if err != nil {
+			return nil, fmt.Errorf("open rdb stream %s failed, %v", rdbFilename, err)
+		}
+		return decompress(rdbFilename, rdbStream)
+	}
+	if rdbFilename == stdinSrc {
+		return decompress(rdbFilename, io.NopCloser(os.Stdin))
+	}
+	rdbFile, err := os.Open(rdbFilename)
+	if err != nil {
+		return nil, fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+	}
+	return decompress(rdbFilename, rdbFile)
+}
+
+// openDecoder 根据数据源类型创建解码器：AOF文件和AOF清单回放为KEY状态，其余按RDB解析
+// 返回的Closer由调用方在解析结束后关闭
+func openDecoder(rdbFilename string) (decoder, io.Closer, error) {
+	if files, err := aofFiles(rdbFilename); err == nil {
+		return newAofDecoder(files), io.NopCloser(nil), nil
+	} else if err != errNotAof {
+		return nil, nil, err
+	}
+	rdbFile, err := openRdb(rdbFilename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return core.NewDecoder(rdbFile), rdbFile, nil
+}
+
+// stdinSrc 表示从标准输入读取RDB
+const stdinSrc = "-"
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	lz4Magic  = []byte{0x04, 0x22, 0x4d, 0x18}
+	rdbMagic  = []byte("REDIS")
+)
+
+// multiCloser 关闭解压Reader后再关闭底层数据源
+type multiCloser struct {
+	io.Reader
+	closers []func() error
+}
+
+func (m *multiCloser) Close() error {
+	var err error
+	for _, closer := range m.closers {
+		if e := closer(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// decompress 根据魔数识别gzip/zstd/lz4压缩的数据源并透明解压，未压缩的原样返回
+func decompress(name string, src io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(src)
+	magic, _ := br.Peek(4)
+	rc := &multiCloser{Reader: br, closers: []func() error{src.Close}}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzReader, err := gzip.NewReader(br)
+		if err != nil {
+			_ = src.Close()
+			return nil, fmt.Errorf("open gzip %s failed, %v", name, err)
+		}
+		rc.Reader = gzReader
+		rc.closers = append([]func() error{gzReader.Close}, rc.closers...)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zstdReader, err := zstd.NewReader(br)
+		if err != nil {
+			_ = src.Close()
+			return nil, fmt.Errorf("open zstd %s failed, %v", name, err)
+		}
+		rc.Reader = zstdReader
+		rc.closers = append([]func() error{func() error { zstdReader.Close(); return nil }}, rc.closers...)
+	case bytes.HasPrefix(magic, lz4Magic):
+		rc.Reader = lz4.NewReader(br)
+	}
+	return rc, nil
+}
+
+// isRdbInput 根据魔数判断文件是否为RDB或压缩文件，用于过滤目录中的文件，AOF文件按后缀识别
+func isRdbInput(path string) bool {
+	if isAofSrc(path) {
+		return true
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	magic := make([]byte, len(rdbMagic))
+	n, _ := io.ReadFull(file, magic)
+	magic = magic[:n]
+	for _, m := range [][]byte{rdbMagic, gzipMagic, zstdMagic, lz4Magic} {
+		if bytes.HasPrefix(magic, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandDir 列出目录下的RDB及压缩文件，按文件名排序
+// 目录中有AOF清单时(Redis 7的appendonlydir)，只返回清单
+// Redis数据目录中同时有RDB和AOF(包括appendonlydir子目录)时，与Redis启动时一样只使用AOF，避免同一份数据统计两次
+func expandDir(dir string) ([]string, error) {
+	if manifest := findManifest(dir); manifest != "" {
+		return []string{manifest}, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read directory %s failed, %v", dir, err)
+	}
+	var rdbs, aofs []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if manifest := findManifest(path); manifest != "" {
+				aofs = append(aofs, manifest)
+			}
+			continue
+		}
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if isAofSrc(path) {
+			aofs = append(aofs, path)
+		} else if isRdbInput(path) {
+			rdbs = append(rdbs, path)
+		}
+	}
+	if len(aofs) > 0 && len(rdbs) > 0 {
+		fmt.Printf("⚠️  目录 %s 中同时有RDB和AOF，与Redis启动时一样使用AOF，跳过: %s\n", dir, strings.Join(rdbs, ", "))
+		return aofs, nil
+	}
+	return append(rdbs, aofs...), nil
+}
+
+// ExpandSources 将逗号分隔的数据源展开为RDB文件列表，支持glob模式、目录和标准输入(-)
+func ExpandSources(src string) ([]string, error) {
+	var files []string
+	for _, part := range strings.Split(src, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if part == stdinSrc {
+			files = append(files, part)
+			continue
+		}
+		paths := []string{part}
+		if strings.ContainsAny(part, "*?[") {
+			matches, err := filepath.Glob(part)
+			if err != nil {
+				return nil, fmt.Errorf("illegal glob pattern: %s", part)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no file matches %s", part)
+			}
+			sort.Strings(matches)
+			paths = matches
+		}
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err == nil && info.IsDir() {
+				dirFiles, err := expandDir(path)
+				if err != nil {
+					return nil, err
+				}
+				files = append(files, dirFiles...)
+				continue
+			}
+			// 不存在的文件留给解析时报错
+			files = append(files, path)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no rdb file found in %s", src)
+	}
+	return files, nil
+}
@@ -0,0 +1,122 @@
+package helper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+var fakeRdb = []byte("REDIS0009\xfa\x09redis-ver\x057.0.0\xff")
+
+func TestDecompress(t *testing.T) {
+	compressors := map[string]func(w io.Writer) io.WriteCloser{
+		"plain": func(w io.Writer) io.WriteCloser { return nopWriteCloser{w} },
+		"gzip":  func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"zstd": func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w)
+			return zw
+		},
+		"lz4": func(w io.Writer) io.WriteCloser { return lz4.NewWriter(w) },
+	}
+	for name, compressor := range compressors {
+		buf := &bytes.Buffer{}
+		w := compressor(buf)
+		_, _ = w.Write(fakeRdb)
+		_ = w.Close()
+		rc, err := decompress(name, io.NopCloser(buf))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		data, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil || !bytes.Equal(data, fakeRdb) {
+			t.Errorf("%s: wrong decompressed data, %v", name, err)
+		}
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestExpandSources(t *testing.T) {
+	dir := t.TempDir()
+	for _, day := range []string{"2026-10-01", "2026-10-02"} {
+		_ = os.MkdirAll(filepath.Join(dir, day), os.ModePerm)
+		_ = os.WriteFile(filepath.Join(dir, day, "dump.rdb"), fakeRdb, 0644)
+	}
+	gzPath := filepath.Join(dir, "2026-10-02", "old.rdb.gz")
+	_ = os.WriteFile(gzPath, []byte{0x1f, 0x8b, 0x08}, 0644)
+	_ = os.WriteFile(filepath.Join(dir, "2026-10-02", "notes.txt"), []byte("hello"), 0644)
+
+	files, err := ExpandSources(filepath.Join(dir, "2026-10-*", "*.rdb"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expect := []string{
+		filepath.Join(dir, "2026-10-01", "dump.rdb"),
+		filepath.Join(dir, "2026-10-02", "dump.rdb"),
+	}
+	if !slices.Equal(files, expect) {
+		t.Errorf("wrong glob expansion: %v", files)
+	}
+
+	files, err = ExpandSources(filepath.Join(dir, "2026-10-02") + ",-")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expect = []string{filepath.Join(dir, "2026-10-02", "dump.rdb"), gzPath, "-"}
+	if !slices.Equal(files, expect) {
+		t.Errorf("wrong directory expansion: %v", files)
+	}
+
+	if _, err = ExpandSources(filepath.Join(dir, "2025-*", "*.rdb")); err == nil {
+		t.Error("expect error when glob matches nothing")
+	}
+
+	// 数据目录中同时有RDB和AOF时只使用AOF
+	dataDir := filepath.Join(dir, "data")
+	_ = os.MkdirAll(filepath.Join(dataDir, "appendonlydir"), os.ModePerm)
+	_ = os.WriteFile(filepath.Join(dataDir, "dump.rdb"), fakeRdb, 0644)
+	manifest := filepath.Join(dataDir, "appendonlydir", "appendonly.aof"+manifestSuffix)
+	_ = os.WriteFile(manifest, []byte("file appendonly.aof.1.base.rdb seq 1 type b\n"), 0644)
+	if files, err = ExpandSources(dataDir); err != nil || !slices.Equal(files, []string{manifest}) {
+		t.Errorf("wrong data directory expansion: %v, %v", files, err)
+	}
+	_ = os.RemoveAll(filepath.Join(dataDir, "appendonlydir"))
+	_ = os.WriteFile(filepath.Join(dataDir, "appendonly.aof"), []byte("*1\r\n$4\r\nPING\r\n"), 0644)
+	if files, err = ExpandSources(dataDir); err != nil || !slices.Equal(files, []string{filepath.Join(dataDir, "appendonly.aof")}) {
+		t.Errorf("wrong data directory expansion: %v, %v", files, err)
+	}
+}
+
+func TestCreateOutPathUnique(t *testing.T) {
+	dir := t.TempDir()
+	first, f1, err := createOutPath("/backups/a/dump.rdb.gz", dir, "-memory.csv", false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_ = f1.Close()
+	second, f2, err := createOutPath("/backups/b/dump.rdb", dir, "-memory.csv", false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_ = f2.Close()
+	if filepath.Base(first) != "dump-memory.csv" || filepath.Base(second) != "dump-1-memory.csv" {
+		t.Errorf("wrong output paths: %s, %s", first, second)
+	}
+}
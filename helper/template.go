@@ -0,0 +1,353 @@
+package helper
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hdt3213/rdb/bytefmt"
+	"github.com/hdt3213/rdb/model"
+)
+
+// templateStat 同一个KEY模板的汇总，如 order:{date}:{id}:items
+// template中纯数字的段都是{id}，holes记录这些段能否确定为{date}或{phone}
+type templateStat struct {
+	db        int
+	template  string
+	holes     []templateHole
+	keyCount  int
+	totalSize int
+	types     map[string]int
+	example   string // 第一个匹配的KEY，便于确认模板
+}
+
+// templateHole 模板中纯数字段的位置和类型，8位数字可能是日期也可能是ID，
+// 同一个模板的所有KEY在该位置都是日期(或手机号)时才输出{date}(或{phone})，否则为{id}
+type templateHole struct {
+	offset int
+	kind   string
+}
+
+// agree 合并另一个KEY或数据源的数字段类型，不一致的位置改为{id}
+func (s *templateStat) agree(holes []templateHole) {
+	if len(holes) != len(s.holes) {
+		return // (other)中混合了不同的模板
+	}
+	for i := range s.holes {
+		if s.holes[i].kind != holes[i].kind {
+			s.holes[i].kind = "{id}"
+		}
+	}
+}
+
+// renderTemplate 将模板中纯数字段的{id}替换为确定的类型
+func renderTemplate(template string, holes []templateHole) string {
+	var b strings.Builder
+	last := 0
+	for _, h := range holes {
+		b.WriteString(template[last:h.offset])
+		b.WriteString(h.kind)
+		last = h.offset + len("{id}")
+	}
+	b.WriteString(template[last:])
+	return b.String()
+}
+
+// typeMix 类型分布，按KEY数量从多到少，如 hash:10 string:2
+func (s *templateStat) typeMix() string {
+	types := make([]string, 0, len(s.types))
+	for typ := range s.types {
+		types = append(types, typ)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if s.types[types[i]] != s.types[types[j]] {
+			return s.types[types[i]] > s.types[types[j]]
+		}
+		return types[i] < types[j]
+	})
+	items := make([]string, len(types))
+	for i, typ := range types {
+		items[i] = typ + ":" + strconv.Itoa(s.types[typ])
+	}
+	return strings.Join(items, " ")
+}
+
+type templateStats map[string]*templateStat // genKey(db, template) -> stat
+
+// stat 返回模板的统计，模板数超过maxPrefixGroups后新的模板计入(other)，
+// 避免未识别的变化段(如base62的token)每个KEY产生一个模板
+func (m templateStats) stat(db int, template string, holes []templateHole, example string) *templateStat {
+	s, created := capGroup(m, template, func(template string) string {
+		return genKey(db, template)
+	}, func(name string) *templateStat {
+		s := &templateStat{db: db, template: name, types: make(map[string]int), example: example}
+		if name == template {
+			s.holes = append(s.holes, holes...)
+		}
+		return s
+	})
+	if !created {
+		s.agree(holes)
+	}
+	return s
+}
+
+func (m templateStats) add(db int, template string, holes []templateHole, typ string, size int, example string) {
+	s := m.stat(db, template, holes, example)
+	s.keyCount++
+	s.totalSize += size
+	s.types[typ]++
+}
+
+func (m templateStats) merge(o templateStats) {
+	for _, src := range o {
+		s := m.stat(src.db, src.template, src.holes, src.example)
+		s.keyCount += src.keyCount
+		s.totalSize += src.totalSize
+		for typ, n := range src.types {
+			s.types[typ] += n
+		}
+	}
+}
+
+// keyTemplate 将KEY按分隔符切分，变化的段替换为占位符，分隔符保持不变，纯数字的段都替换为{id}
+// 如 order:20261017:883421:items 得到 order:{id}:{id}:items
+func keyTemplate(key string, separators []string) string {
+	template, _ := keyTemplateHoles(key, separators)
+	return template
+}
+
+// keyTemplateHoles 与keyTemplate相同，另外返回每个纯数字段按单个KEY识别的类型
+// 如 order:20261017:883421:items 的两个数字段分别为{date}和{id}
+func keyTemplateHoles(key string, separators []string) (string, []templateHole) {
+	separators = defaultSeparators(separators)
+	var b strings.Builder
+	var holes []templateHole
+	for len(key) > 0 {
+		end, sepLen := len(key), 0
+		for _, sep := range separators {
+			if sep == "" {
+				continue
+			}
+			if i := strings.Index(key[:end], sep); i >= 0 {
+				end, sepLen = i, len(sep)
+			}
+		}
+		seg := key[:end]
+		if isDigits(seg) {
+			holes = append(holes, templateHole{offset: b.Len(), kind: segmentPattern(seg)})
+		}
+		b.WriteString(familyPattern(seg))
+		b.WriteString(key[end : end+sepLen])
+		key = key[end+sepLen:]
+	}
+	return b.String(), holes
+}
+
+// familyPattern 与segmentPattern相同，但纯数字的段统一为{id}，是否为日期或手机号由同一模板的所有KEY决定
+func familyPattern(seg string) string {
+	if isDigits(seg) {
+		return "{id}"
+	}
+	return segmentPattern(seg)
+}
+
+// segmentPattern 识别变化的段: {uuid}, {date}, {phone}, {id}, {hex}，其余原样返回
+func segmentPattern(seg string) string {
+	switch {
+	case seg == "":
+		return seg
+	case isUUID(seg):
+		return "{uuid}"
+	case isDate(seg):
+		return "{date}"
+	case isPhone(seg):
+		return "{phone}"
+	case isDigits(seg):
+		return "{id}"
+	case isHash(seg):
+		return "{hex}"
+	}
+	return seg
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// isUUID 8-4-4-4-12 格式，去掉连字符的32位十六进制无法与MD5区分，按{hex}处理
+func isUUID(s string) bool {
+	if len(s) != 36 || strings.Count(s, "-") != 4 {
+		return false
+	}
+	for i, part := range strings.Split(s, "-") {
+		if !isHex(part) || len(part) != []int{8, 4, 4, 4, 12}[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isDate 支持 20261017、2026-10-17、2026/10/17
+func isDate(s string) bool {
+	if len(s) == 10 && (s[4] == '-' && s[7] == '-' || s[4] == '/' && s[7] == '/') {
+		s = s[:4] + s[5:7] + s[8:]
+	}
+	if len(s) != 8 || !isDigits(s) {
+		return false
+	}
+	year, _ := strconv.Atoi(s[:4])
+	month, _ := strconv.Atoi(s[4:6])
+	day, _ := strconv.Atoi(s[6:])
+	return year >= 1970 && year <= 2100 && month >= 1 && month <= 12 && day >= 1 && day <= 31
+}
+
+// isPhone 11位手机号(1开头)，或带国家码的 +86 开头号码
+func isPhone(s string) bool {
+	if strings.HasPrefix(s, "+") {
+		return len(s) >= 8 && len(s) <= 16 && isDigits(s[1:])
+	}
+	return len(s) == 11 && s[0] == '1' && isDigits(s)
+}
+
+// isHash 16位以上的十六进制串，如MD5、SHA1、ObjectId，需要同时包含数字，避免把 deadbeefcafe 之类的单词当作哈希
+func isHash(s string) bool {
+	return len(s) >= 16 && isHex(s) && strings.IndexAny(s, "0123456789") >= 0
+}
+
+// templateAnalyzer 按KEY模板汇总KEY数量、内存占用和类型分布，输出最大的N个模板
+// 多个数据源时另外输出所有数据源合并后的结果
+type templateAnalyzer struct {
+	cfg        *AnalyzeConfig
+	topN       int
+	separators []string
+	mu         sync.Mutex // 保护merged和sources
+	merged     templateStats
+	sources    int
+}
+
+func newTemplateAnalyzer(cfg *AnalyzeConfig) (Analyzer, error) {
+	if cfg.TopN < 0 {
+		return nil, errors.New("结果数量必须大于0")
+	}
+	topN := cfg.TopN
+	if topN == 0 {
+		topN = math.MaxInt
+	}
+	return &templateAnalyzer{cfg: cfg, topN: topN, separators: cfg.Separators, merged: make(templateStats)}, nil
+}
+
+func (a *templateAnalyzer) Begin(rdbFilename string) (FileAnalyzer, error) {
+	// 先创建文件占用文件名，分析完成后再写入
+	outputPath, outputFile, err := a.cfg.CreateOutput(rdbFilename, "-template.csv")
+	if err != nil {
+		return nil, fmt.Errorf("创建输出文件失败: %v", err)
+	}
+	return &templateFileAnalyzer{
+		templateAnalyzer: a,
+		outputPath:       outputPath,
+		outputFile:       outputFile,
+		stats:            make(templateStats),
+	}, nil
+}
+
+func (a *templateAnalyzer) Close() ([]string, error) {
+	if a.sources < 2 {
+		return nil, nil
+	}
+	outputPath, outputFile, err := a.cfg.CreateOutput("all", "-template.csv")
+	if err != nil {
+		return nil, fmt.Errorf("创建输出文件失败: %v", err)
+	}
+	defer func() {
+		_ = outputFile.Close()
+	}()
+	return []string{outputPath}, writeTemplates(outputFile, a.merged, a.topN)
+}
+
+type templateFileAnalyzer struct {
+	*templateAnalyzer
+	outputPath string
+	outputFile *os.File
+	stats      templateStats
+}
+
+func (fa *templateFileAnalyzer) Add(object model.RedisObject) {
+	template, holes := keyTemplateHoles(object.GetKey(), fa.separators)
+	fa.stats.add(object.GetDBIndex(), template, holes, object.GetType(), object.GetSize(), object.GetKey())
+}
+
+func (fa *templateFileAnalyzer) Finish() ([]string, error) {
+	defer func() {
+		_ = fa.outputFile.Close()
+	}()
+	// 先写入本文件的结果，合并后stats中的对象可能被其他数据源修改
+	err := writeTemplates(fa.outputFile, fa.stats, fa.topN)
+	fa.mu.Lock()
+	fa.sources++
+	fa.merged.merge(fa.stats)
+	fa.mu.Unlock()
+	return []string{fa.outputPath}, err
+}
+
+// writeTemplates 按内存占用从大到小输出前topN个模板
+func writeTemplates(outputFile *os.File, stats templateStats, topN int) error {
+	list := make([]*templateStat, 0, len(stats))
+	for _, s := range stats {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].totalSize != list[j].totalSize {
+			return list[i].totalSize > list[j].totalSize
+		}
+		if list[i].db != list[j].db {
+			return list[i].db < list[j].db
+		}
+		return list[i].template < list[j].template
+	})
+	if len(list) > topN {
+		list = list[:topN]
+	}
+	_, err := outputFile.WriteString("数据库,模板,KEY数量,KEY大小,KEY大小[K/M/G],类型分布,示例KEY\n")
+	if err != nil {
+		return fmt.Errorf("写入CSV头部失败: %v", err)
+	}
+	csvWriter := csv.NewWriter(outputFile)
+	for _, s := range list {
+		err = csvWriter.Write([]string{
+			strconv.Itoa(s.db),
+			renderTemplate(s.template, s.holes),
+			strconv.Itoa(s.keyCount),
+			strconv.Itoa(s.totalSize),
+			bytefmt.FormatSize(uint64(s.totalSize)),
+			s.typeMix(),
+			s.example,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
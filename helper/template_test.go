@@ -0,0 +1,91 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyTemplate(t *testing.T) {
+	cases := map[string]string{
+		"order:20261017:883421:items":                  "order:{date}:{id}:items",
+		"user:13800138000:profile":                     "user:{phone}:profile",
+		"session:550e8400-e29b-41d4-a716-446655440000": "session:{uuid}",
+		"cache:d41d8cd98f00b204e9800998ecf8427e":       "cache:{hex}",
+		"report:2026-10-17":                            "report:{date}",
+		"config:deadbeefcafebabe":                      "config:deadbeefcafebabe",
+		"counter":                                      "counter",
+		"user:1.name":                                  "user:{id}.name",
+		"a::b:":                                        "a::b:",
+		"order:20261399:1":                             "order:{id}:{id}",
+	}
+	for key, expect := range cases {
+		if got := renderTemplate(keyTemplateHoles(key, []string{":", "."})); got != expect {
+			t.Errorf("%s: expect %s, got %s", key, expect, got)
+		}
+	}
+}
+
+func TestTemplateAnalyse(t *testing.T) {
+	dir := t.TempDir()
+	srcs := []string{
+		writeTestAof(t, dir, "node1.aof",
+			[]string{"SET", "order:20261017:1:items", "a"},
+			[]string{"RPUSH", "order:20261016:2:items", "a", "b"},
+			[]string{"SET", "counter", "1"},
+			// 同一模板中有的像日期有的不是，统一为{id}
+			[]string{"SET", "user:20261017", "1"},
+			[]string{"SET", "user:12345678", "1"},
+			[]string{"SET", "phone:13800138000", "1"},
+			[]string{"SET", "phone:99", "1"},
+		),
+		writeTestAof(t, dir, "node2.aof",
+			[]string{"SET", "order:20261015:3:items", "a"},
+		),
+	}
+	workDir := filepath.Join(dir, "report")
+	_ = os.MkdirAll(workDir, os.ModePerm)
+	cfg := AnalyzeConfig{WorkDir: workDir, WorkDirName: "report"}
+	a, _ := newTemplateAnalyzer(&cfg)
+	for _, src := range srcs {
+		if _, _, err := analyseFile(src, []Analyzer{a}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	files, err := a.Close()
+	if err != nil || len(files) != 1 || filepath.Base(files[0]) != "all-template.csv" {
+		t.Errorf("wrong merged output: %v, %v", files, err)
+		return
+	}
+	content, _ := os.ReadFile(files[0])
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[1], "0,order:{date}:{id}:items,3,") ||
+		!strings.Contains(lines[1], ",string:2 list:1,") || !strings.HasPrefix(lines[2], "0,phone:{id},2,") ||
+		!strings.HasPrefix(lines[3], "0,user:{id},2,") || !strings.HasPrefix(lines[4], "0,counter,1,") {
+		t.Errorf("wrong templates:\n%s", content)
+	}
+}
+
+func TestTemplateLimit(t *testing.T) {
+	defer func(n int) { maxPrefixGroups = n }(maxPrefixGroups)
+	maxPrefixGroups = 2
+	stats := make(templateStats)
+	for _, template := range []string{"a", "b", "c", "d", "a"} {
+		stats.add(0, template, nil, "string", 1, template)
+	}
+	if len(stats) != 3 || stats[genKey(0, "a")].keyCount != 2 || stats[genKey(0, otherPrefix)].keyCount != 2 {
+		t.Errorf("wrong templates: %v", stats)
+	}
+	// 合并时同样受限，合并顺序不定，只检查总数
+	merged := make(templateStats)
+	merged.merge(stats)
+	total := 0
+	for _, s := range merged {
+		total += s.keyCount
+	}
+	if len(merged) > 3 || total != 5 {
+		t.Errorf("wrong merged templates: %v", merged)
+	}
+}
@@ -25,6 +25,11 @@ func (tl *topList) add(x Sized) {
 	}
 }
 
+// accepts 判断size能否进入排名，已满且不大于最后一名时不需要构造对象
+func (tl *topList) accepts(size int) bool {
+	return len(tl.list) < tl.capacity || size > tl.list[len(tl.list)-1].GetSize()
+}
+
 func newToplist(cap int) *topList {
 	return &topList{
 		capacity: cap,
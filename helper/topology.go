@@ -0,0 +1,275 @@
+package helper
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// 从节点选择策略
+const (
+	ReplicaPolicyLag    = "lag"    // 复制延迟最小
+	ReplicaPolicyMemory = "memory" // 内存占用最少
+	ReplicaPolicyZone   = "zone"   // 优先指定可用区，区内按复制延迟选择
+)
+
+// ShardNode 分片中的一个节点
+type ShardNode struct {
+	ID       string
+	Addr     string
+	Hostname string // Redis 7 cluster-announce-hostname，没有时为空
+	Healthy  bool
+}
+
+// Shard 一个主节点及其从节点
+type Shard struct {
+	Master   ShardNode
+	Replicas []ShardNode
+	Slots    string // 集群分片负责的slot范围，如 0-5460，非集群时为空
+	// Name 分片的稳定标识，故障转移或选择不同的节点导出时不变，用于趋势数据区分实例
+	Name string
+}
+
+// clusterSlots 提取CLUSTER NODES中的slot范围，忽略正在迁移的 [slot->-id] 项
+func clusterSlots(fields []string) string {
+	var slots []string
+	for _, field := range fields {
+		if !strings.HasPrefix(field, "[") {
+			slots = append(slots, field)
+		}
+	}
+	return strings.Join(slots, ",")
+}
+
+// shardName 返回节点所在分片的稳定标识，节点不属于任何分片时返回节点地址
+func (rc *RedisConnection) shardName(node string) string {
+	for _, shard := range rc.Shards {
+		if shard.Master.Addr == node {
+			return shard.Name
+		}
+		for _, replica := range shard.Replicas {
+			if replica.Addr == node {
+				return shard.Name
+			}
+		}
+	}
+	return node
+}
+
+// parseInfo 解析INFO命令的输出
+func parseInfo(infoStr string) map[string]string {
+	info := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(infoStr))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			info[k] = v
+		}
+	}
+	return info
+}
+
+// parseInfoFields 解析INFO中 slave0:ip=...,port=... 形式的值
+func parseInfoFields(value string) map[string]string {
+	fields := make(map[string]string)
+	for _, kv := range strings.Split(value, ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// parseClusterNodes 解析CLUSTER NODES输出，按主节点组织分片
+// 格式: <id> <ip:port@cport[,hostname]> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+func parseClusterNodes(clusterNodesStr string) []*Shard {
+	var shards []*Shard
+	byMasterID := make(map[string]*Shard)
+	type replicaOf struct {
+		node     ShardNode
+		masterID string
+	}
+	var replicas []replicaOf
+
+	scanner := bufio.NewScanner(strings.NewReader(clusterNodesStr))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || !strings.Contains(fields[1], "@") {
+			continue
+		}
+		addr, rest, _ := strings.Cut(fields[1], "@")
+		node := ShardNode{ID: fields[0], Addr: addr}
+		if _, hostname, ok := strings.Cut(rest, ","); ok {
+			node.Hostname = hostname
+		}
+		flags := strings.Split(fields[2], ",")
+		node.Healthy = fields[7] == "connected"
+		for _, flag := range flags {
+			switch flag {
+			case "fail", "fail?", "handshake", "noaddr":
+				node.Healthy = false
+			}
+		}
+		for _, flag := range flags {
+			if flag == "master" {
+				shard := &Shard{Master: node, Slots: clusterSlots(fields[8:])}
+				shards = append(shards, shard)
+				byMasterID[node.ID] = shard
+			} else if flag == "slave" {
+				replicas = append(replicas, replicaOf{node, fields[3]})
+			}
+		}
+	}
+	for _, r := range replicas {
+		shard, ok := byMasterID[r.masterID]
+		if !ok {
+			fmt.Printf("「连接」- 从节点 %s 的主节点 %s 不在集群中，忽略\n", r.node.Addr, r.masterID)
+			continue
+		}
+		shard.Replicas = append(shard.Replicas, r.node)
+	}
+	return shards
+}
+
+// standaloneShard 根据单机节点的INFO构建分片，当前节点可能是主节点或从节点
+func standaloneShard(hostPort string, info map[string]string) *Shard {
+	shard := &Shard{}
+	if info["role"] == "master" {
+		shard.Master = ShardNode{Addr: hostPort, Healthy: true}
+		for i := 0; ; i++ {
+			value, ok := info["slave"+strconv.Itoa(i)]
+			if !ok {
+				break
+			}
+			fields := parseInfoFields(value)
+			shard.Replicas = append(shard.Replicas, ShardNode{
+				Addr:    net.JoinHostPort(fields["ip"], fields["port"]),
+				Healthy: fields["state"] == "online",
+			})
+		}
+		return shard
+	}
+	shard.Master = ShardNode{
+		Addr:    net.JoinHostPort(info["master_host"], info["master_port"]),
+		Healthy: info["master_link_status"] == "up",
+	}
+	// 用户直接指定的从节点总是视为可用
+	shard.Replicas = []ShardNode{{Addr: hostPort, Healthy: true}}
+	return shard
+}
+
+// nodeInfo 查询单个节点的INFO
+func (rc *RedisConnection) nodeInfo(addr string, sections ...string) (map[string]string, error) {
+	client := rc.createNodeClient(addr)
+	defer client.Close()
+	infoStr, err := client.Info(context.Background(), sections...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseInfo(infoStr), nil
+}
+
+// inZone 判断节点是否属于可用区，zone可以是CIDR网段，也可以是主机名中包含的字符串
+func inZone(node ShardNode, zone string) bool {
+	if _, ipNet, err := net.ParseCIDR(zone); err == nil {
+		ip := net.ParseIP(nodeHost(node.Addr))
+		return ip != nil && ipNet.Contains(ip)
+	}
+	return strings.Contains(node.Hostname, zone) || strings.Contains(node.Addr, zone)
+}
+
+// replicaCandidate 可供选择的从节点及其复制、内存状态
+type replicaCandidate struct {
+	node       ShardNode
+	replOffset int64
+	usedMemory int64
+}
+
+// candidates 查询分片中健康的从节点，复制链路断开或查询失败的从节点视为不健康
+func (rc *RedisConnection) candidates(shard *Shard) []replicaCandidate {
+	var result []replicaCandidate
+	for _, replica := range shard.Replicas {
+		if !replica.Healthy {
+			continue
+		}
+		info, err := rc.nodeInfo(replica.Addr, "replication", "memory")
+		if err != nil {
+			fmt.Printf("  ⚠️  从节点 %s 查询失败，跳过: %v\n", replica.Addr, err)
+			continue
+		}
+		if info["master_link_status"] != "up" {
+			fmt.Printf("  ⚠️  从节点 %s 复制链路异常(%s)，跳过\n", replica.Addr, info["master_link_status"])
+			continue
+		}
+		offset, _ := strconv.ParseInt(info["slave_repl_offset"], 10, 64)
+		memory, _ := strconv.ParseInt(info["used_memory"], 10, 64)
+		result = append(result, replicaCandidate{replica, offset, memory})
+	}
+	return result
+}
+
+// pickReplica 按策略从候选从节点中选择一个
+func pickReplica(candidates []replicaCandidate, policy string, zone string) replicaCandidate {
+	if policy == ReplicaPolicyZone && zone != "" {
+		var local []replicaCandidate
+		for _, c := range candidates {
+			if inZone(c.node, zone) {
+				local = append(local, c)
+			}
+		}
+		if len(local) > 0 {
+			candidates = local
+		}
+	}
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if policy == ReplicaPolicyMemory {
+			if c.usedMemory < best.usedMemory {
+				best = c
+			}
+		} else if c.replOffset > best.replOffset { // 复制偏移量越大，延迟越小
+			best = c
+		}
+	}
+	return best
+}
+
+// SelectNodes 为每个分片选择一个用于导出RDB的节点
+// useMaster时选择主节点，否则按策略选择一个健康的从节点，分片没有健康的从节点时回退到主节点
+func (rc *RedisConnection) SelectNodes(useMaster bool, policy string, zone string) ([]string, error) {
+	switch policy {
+	case "":
+		policy = ReplicaPolicyLag
+	case ReplicaPolicyLag, ReplicaPolicyMemory, ReplicaPolicyZone:
+	default:
+		return nil, fmt.Errorf("unsupported replica policy: %s", policy)
+	}
+	var nodes []string
+	for _, shard := range rc.Shards {
+		if useMaster {
+			nodes = append(nodes, shard.Master.Addr)
+			continue
+		}
+		candidates := rc.candidates(shard)
+		if len(candidates) == 0 {
+			if !shard.Master.Healthy {
+				fmt.Printf("  ❌ 分片 %s 没有可用的节点，跳过\n", shard.Master.Addr)
+				continue
+			}
+			fmt.Printf("  ⚠️  分片 %s 没有健康的Slave节点，回退使用Master节点\n", shard.Master.Addr)
+			nodes = append(nodes, shard.Master.Addr)
+			continue
+		}
+		nodes = append(nodes, pickReplica(candidates, policy, zone).node.Addr)
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("没有可用于导出RDB的节点")
+	}
+	return nodes, nil
+}
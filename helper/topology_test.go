@@ -0,0 +1,101 @@
+package helper
+
+import "testing"
+
+const clusterNodesOutput = `07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004,redis-az1-4 slave e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 0 1426238317239 4 connected
+67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 127.0.0.1:30002@31002 master - 0 1426238316232 2 connected 5461-10922
+292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f 127.0.0.1:30003@31003 master - 0 1426238318243 3 connected 10923-16383
+6ec23923021cf3ffec47632106199cb7f496ce01 127.0.0.1:30005@31005 slave,fail 67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 0 1426238316232 5 connected
+824fe116063bc5fcf9f4ffd895bc17aee7731ac3 127.0.0.1:30006@31006,redis-az2-6 slave 292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f 0 1426238317741 6 connected
+e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001@31001 myself,master - 0 0 1 connected 0-5460
+a1b2c3d4e5f60718293a4b5c6d7e8f9012345678 127.0.0.1:30007@31007,redis-az2-7 slave e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 0 1426238317741 7 disconnected
+`
+
+func TestParseClusterNodes(t *testing.T) {
+	shards := parseClusterNodes(clusterNodesOutput)
+	if len(shards) != 3 {
+		t.Errorf("expect 3 shards, got %d", len(shards))
+		return
+	}
+	expect := map[string][]string{
+		"127.0.0.1:30001": {"127.0.0.1:30004", "127.0.0.1:30007"},
+		"127.0.0.1:30002": {"127.0.0.1:30005"},
+		"127.0.0.1:30003": {"127.0.0.1:30006"},
+	}
+	expectHealthy := map[string]bool{
+		"127.0.0.1:30004": true,
+		"127.0.0.1:30005": false,
+		"127.0.0.1:30006": true,
+		"127.0.0.1:30007": false,
+	}
+	for _, shard := range shards {
+		replicas := expect[shard.Master.Addr]
+		if len(replicas) != len(shard.Replicas) {
+			t.Errorf("wrong replicas of %s", shard.Master.Addr)
+			continue
+		}
+		for i, replica := range shard.Replicas {
+			if replica.Addr != replicas[i] {
+				t.Errorf("wrong replica %s of %s", replica.Addr, shard.Master.Addr)
+			}
+			if replica.Healthy != expectHealthy[replica.Addr] {
+				t.Errorf("wrong health of %s", replica.Addr)
+			}
+		}
+	}
+	if shards[0].Slots != "5461-10922" || shards[2].Slots != "0-5460" {
+		t.Errorf("wrong slots: %s, %s", shards[0].Slots, shards[2].Slots)
+	}
+	rc := &RedisConnection{HostPort: "127.0.0.1:30001", IsCluster: true, Shards: shards}
+	rc.nameShards()
+	// 从节点和主节点属于同一分片，故障转移后标识不变
+	if name := rc.shardName("127.0.0.1:30004"); name != "127.0.0.1:30001/0-5460" || rc.shardName("127.0.0.1:30001") != name {
+		t.Errorf("wrong shard name: %s", name)
+	}
+	if shards[0].Master.Hostname != "" || shards[2].Replicas[0].Hostname != "redis-az1-4" {
+		t.Error("wrong hostname")
+	}
+}
+
+func TestStandaloneShard(t *testing.T) {
+	info := parseInfo("# Replication\r\nrole:master\r\nconnected_slaves:2\r\n" +
+		"slave0:ip=10.0.0.2,port=6379,state=online,offset=100,lag=0\r\n" +
+		"slave1:ip=10.0.0.3,port=6380,state=wait_bgsave,offset=0,lag=0\r\n")
+	shard := standaloneShard("10.0.0.1:6379", info)
+	if shard.Master.Addr != "10.0.0.1:6379" || len(shard.Replicas) != 2 {
+		t.Error("wrong standalone master shard")
+		return
+	}
+	if !shard.Replicas[0].Healthy || shard.Replicas[1].Healthy || shard.Replicas[1].Addr != "10.0.0.3:6380" {
+		t.Error("wrong standalone replicas")
+	}
+
+	info = parseInfo("role:slave\r\nmaster_host:10.0.0.1\r\nmaster_port:6379\r\nmaster_link_status:up\r\n")
+	shard = standaloneShard("10.0.0.2:6379", info)
+	if shard.Master.Addr != "10.0.0.1:6379" || len(shard.Replicas) != 1 || shard.Replicas[0].Addr != "10.0.0.2:6379" {
+		t.Error("wrong standalone replica shard")
+	}
+}
+
+func TestPickReplica(t *testing.T) {
+	candidates := []replicaCandidate{
+		{node: ShardNode{Addr: "10.1.0.1:6379", Hostname: "redis-az1"}, replOffset: 90, usedMemory: 100},
+		{node: ShardNode{Addr: "10.2.0.1:6379", Hostname: "redis-az2"}, replOffset: 100, usedMemory: 300},
+		{node: ShardNode{Addr: "10.2.0.2:6379", Hostname: "redis-az2"}, replOffset: 80, usedMemory: 50},
+	}
+	if c := pickReplica(candidates, ReplicaPolicyLag, ""); c.node.Addr != "10.2.0.1:6379" {
+		t.Errorf("lag policy picked %s", c.node.Addr)
+	}
+	if c := pickReplica(candidates, ReplicaPolicyMemory, ""); c.node.Addr != "10.2.0.2:6379" {
+		t.Errorf("memory policy picked %s", c.node.Addr)
+	}
+	if c := pickReplica(candidates, ReplicaPolicyZone, "az1"); c.node.Addr != "10.1.0.1:6379" {
+		t.Errorf("zone policy picked %s", c.node.Addr)
+	}
+	if c := pickReplica(candidates, ReplicaPolicyZone, "10.2.0.0/16"); c.node.Addr != "10.2.0.1:6379" {
+		t.Errorf("zone cidr policy picked %s", c.node.Addr)
+	}
+	if c := pickReplica(candidates, ReplicaPolicyZone, "az3"); c.node.Addr != "10.2.0.1:6379" {
+		t.Errorf("zone policy without local replica picked %s", c.node.Addr)
+	}
+}
@@ -0,0 +1,407 @@
+package helper
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hdt3213/rdb/bytefmt"
+	"github.com/hdt3213/rdb/model"
+)
+
+// trendStoreName 趋势数据保存在数据目录下，每行一个快照的JSON汇总，只追加不修改
+const trendStoreName = "redis-tools-trend.jsonl"
+
+// trendPrefixLimit 每个快照保存的最大前缀数，按大小取前N个，其余计入(other)
+var trendPrefixLimit = 1000
+
+// TrendStorePath 返回数据目录下的趋势数据文件
+func TrendStorePath(dataDir string) string {
+	return filepath.Join(dataDir, trendStoreName)
+}
+
+type trendCounter struct {
+	Count int `json:"count"`
+	Size  int `json:"size"`
+}
+
+type trendPrefix struct {
+	DB     int    `json:"db"`
+	Prefix string `json:"prefix"`
+	trendCounter
+}
+
+// trendSnapshot 一个实例在一次分析中的汇总
+type trendSnapshot struct {
+	Time     time.Time                `json:"time"`
+	Instance string                   `json:"instance"`
+	Keys     int                      `json:"keys"`
+	Size     int                      `json:"size"`
+	Types    map[string]*trendCounter `json:"types"`
+	Prefixes []*trendPrefix           `json:"prefixes"`
+}
+
+// trendRecorder 分析时顺带汇总每个数据源的前缀和类型，追加到趋势数据文件，不生成结果文件
+type trendRecorder struct {
+	store      string
+	separators []string
+	instance   string
+	now        time.Time  // 同一次分析的所有数据源使用相同的快照时间
+	mu         sync.Mutex // 并行分析时串行写入
+}
+
+func newTrendRecorder(cfg *AnalyzeConfig) *trendRecorder {
+	return &trendRecorder{store: cfg.TrendStore, separators: cfg.separators(), instance: cfg.Instance, now: time.Now()}
+}
+
+// trendInstance 趋势数据中的实例名称，不使用文件路径或节点地址，否则同一实例不同日期的备份、
+// 主从切换或选择了不同的从节点都会成为不同的实例
+// 从Redis导出的数据源使用分片的稳定标识，本地文件需要通过 -instance 指定
+func trendInstance(src string, instance string) (string, error) {
+	if instance != "" {
+		return instance, nil
+	}
+	if name, ok := sourceInstance(src); ok {
+		return name, nil
+	}
+	return "", fmt.Errorf("本地文件 %s 请使用 -instance 指定趋势数据的实例名称", src)
+}
+
+func (a *trendRecorder) Begin(rdbFilename string) (FileAnalyzer, error) {
+	instance, err := trendInstance(rdbFilename, a.instance)
+	if err != nil {
+		return nil, err
+	}
+	return &trendFileRecorder{
+		trendRecorder: a,
+		snapshot: &trendSnapshot{
+			Time:     a.now,
+			Instance: instance,
+			Types:    make(map[string]*trendCounter),
+		},
+		prefixes: make(map[string]*trendPrefix),
+	}, nil
+}
+
+func (a *trendRecorder) Close() ([]string, error) {
+	return nil, nil
+}
+
+// append 追加一行快照，一次写入保证多个进程同时追加时行不交错
+func (a *trendRecorder) append(snapshot *trendSnapshot) error {
+	line, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.store, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("打开趋势数据文件失败: %v", err)
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+type trendFileRecorder struct {
+	*trendRecorder
+	snapshot *trendSnapshot
+	prefixes map[string]*trendPrefix
+}
+
+func (fa *trendFileRecorder) Add(object model.RedisObject) {
+	s := fa.snapshot
+	size := object.GetSize()
+	s.Keys++
+	s.Size += size
+	c := s.Types[object.GetType()]
+	if c == nil {
+		c = &trendCounter{}
+		s.Types[object.GetType()] = c
+	}
+	c.Count++
+	c.Size += size
+	key := object.GetKey()
+	db := object.GetDBIndex()
+	p, _ := capGroup(fa.prefixes, key[:nextBoundary(key, fa.separators)], func(prefix string) string {
+		return genKey(db, prefix)
+	}, func(prefix string) *trendPrefix {
+		return &trendPrefix{DB: db, Prefix: prefix}
+	})
+	p.Count++
+	p.Size += size
+}
+
+func (fa *trendFileRecorder) Finish() ([]string, error) {
+	s := fa.snapshot
+	for _, p := range fa.prefixes {
+		s.Prefixes = append(s.Prefixes, p)
+	}
+	sortTrendPrefixes(s.Prefixes)
+	if len(s.Prefixes) > trendPrefixLimit {
+		others := make(map[int]*trendPrefix)
+		for _, p := range s.Prefixes[trendPrefixLimit:] {
+			o := others[p.DB]
+			if o == nil {
+				o = &trendPrefix{DB: p.DB, Prefix: otherPrefix}
+				others[p.DB] = o
+			}
+			o.Count += p.Count
+			o.Size += p.Size
+		}
+		s.Prefixes = s.Prefixes[:trendPrefixLimit]
+		for _, o := range others {
+			s.Prefixes = append(s.Prefixes, o)
+		}
+		sortTrendPrefixes(s.Prefixes)
+	}
+	if err := fa.append(s); err != nil {
+		return nil, fmt.Errorf("写入趋势数据失败: %v", err)
+	}
+	return nil, nil
+}
+
+// Abort 解析失败时不追加快照，残缺的快照会被误认为数据减少，输出提示用于解释趋势中缺少的快照
+func (fa *trendFileRecorder) Abort(err error) {
+	fmt.Printf("  ⚠️  %s 解析失败，未记录趋势快照: %v\n", fa.snapshot.Instance, err)
+}
+
+func sortTrendPrefixes(prefixes []*trendPrefix) {
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].Size != prefixes[j].Size {
+			return prefixes[i].Size > prefixes[j].Size
+		}
+		if prefixes[i].DB != prefixes[j].DB {
+			return prefixes[i].DB < prefixes[j].DB
+		}
+		return prefixes[i].Prefix < prefixes[j].Prefix
+	})
+}
+
+// loadTrend 读取趋势数据，按实例分组并按时间排序，instance不为空时只读取包含该字符串的实例
+func loadTrend(store string, instance string) (map[string][]*trendSnapshot, error) {
+	f, err := os.Open(store)
+	if err != nil {
+		return nil, fmt.Errorf("打开趋势数据文件失败: %v", err)
+	}
+	defer f.Close()
+	snapshots := make(map[string][]*trendSnapshot)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		s := &trendSnapshot{}
+		if err := json.Unmarshal(scanner.Bytes(), s); err != nil {
+			// 写入中断的行跳过，不影响其他快照
+			fmt.Printf("⚠️  趋势数据第 %d 行无法解析，已跳过: %v\n", n, err)
+			continue
+		}
+		if instance == "" || strings.Contains(s.Instance, instance) {
+			snapshots[s.Instance] = append(snapshots[s.Instance], s)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取趋势数据文件失败: %v", err)
+	}
+	for _, list := range snapshots {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Time.Before(list[j].Time)
+		})
+	}
+	return snapshots, nil
+}
+
+// trendRow 一个前缀在最近N个快照中的变化
+type trendRow struct {
+	instance    string
+	db          int
+	prefix      string
+	first, last *trendSnapshot
+	from, to    trendCounter
+}
+
+func (r *trendRow) growth() int {
+	return r.to.Size - r.from.Size
+}
+
+// rate 最近N个快照的大小增长率，第一个快照中没有该前缀时返回-1
+func (r *trendRow) rate() float64 {
+	if r.from.Size == 0 {
+		return -1
+	}
+	return float64(r.growth()) * 100 / float64(r.from.Size)
+}
+
+// perDay 平均每天的大小增长
+func (r *trendRow) perDay() int {
+	days := r.last.Time.Sub(r.first.Time).Hours() / 24
+	if days <= 0 {
+		return 0
+	}
+	return int(float64(r.growth()) / days)
+}
+
+// fast 增长率超过threshold(百分比)，新出现的前缀也算增长过快
+func (r *trendRow) fast(threshold float64) bool {
+	if r.growth() <= 0 {
+		return false
+	}
+	rate := r.rate()
+	return rate < 0 || rate > threshold
+}
+
+// trendRows 计算每个实例最近n个快照中总量和每个前缀的变化
+func trendRows(snapshots map[string][]*trendSnapshot, n int) []*trendRow {
+	var rows []*trendRow
+	for instance, list := range snapshots {
+		if len(list) > n {
+			list = list[len(list)-n:]
+		}
+		first, last := list[0], list[len(list)-1]
+		rows = append(rows, &trendRow{
+			instance: instance,
+			db:       -1,
+			prefix:   "(all)",
+			first:    first,
+			last:     last,
+			from:     trendCounter{Count: first.Keys, Size: first.Size},
+			to:       trendCounter{Count: last.Keys, Size: last.Size},
+		})
+		prefixes := make(map[string]*trendRow)
+		row := func(p *trendPrefix) *trendRow {
+			k := genKey(p.DB, p.Prefix)
+			r := prefixes[k]
+			if r == nil {
+				r = &trendRow{instance: instance, db: p.DB, prefix: p.Prefix, first: first, last: last}
+				prefixes[k] = r
+			}
+			return r
+		}
+		for _, p := range first.Prefixes {
+			row(p).from = p.trendCounter
+		}
+		for _, p := range last.Prefixes {
+			row(p).to = p.trendCounter
+		}
+		for _, r := range prefixes {
+			rows = append(rows, r)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].instance != rows[j].instance {
+			return rows[i].instance < rows[j].instance
+		}
+		if rows[i].db != rows[j].db {
+			return rows[i].db < rows[j].db
+		}
+		if absInt(rows[i].growth()) != absInt(rows[j].growth()) {
+			return absInt(rows[i].growth()) > absInt(rows[j].growth())
+		}
+		return rows[i].prefix < rows[j].prefix
+	})
+	return rows
+}
+
+func writeTrend(outputFile *os.File, rows []*trendRow, threshold float64) error {
+	_, err := outputFile.WriteString("实例,数据库,前缀,首次时间,最近时间,首次KEY个数,最近KEY个数,首次大小,最近大小,大小变化,大小变化[K/M/G],增长率,每天增长,增长过快\n")
+	if err != nil {
+		return fmt.Errorf("写入CSV头部失败: %v", err)
+	}
+	csvWriter := csv.NewWriter(outputFile)
+	for _, r := range rows {
+		db, rate, fast := "", "new", ""
+		if r.db >= 0 {
+			db = strconv.Itoa(r.db)
+		}
+		if r.rate() >= 0 {
+			rate = strconv.FormatFloat(r.rate(), 'f', 1, 64) + "%"
+		}
+		if r.fast(threshold) {
+			fast = "是"
+		}
+		err = csvWriter.Write([]string{
+			r.instance,
+			db,
+			r.prefix,
+			r.first.Time.Format(time.DateTime),
+			r.last.Time.Format(time.DateTime),
+			strconv.Itoa(r.from.Count),
+			strconv.Itoa(r.to.Count),
+			strconv.Itoa(r.from.Size),
+			strconv.Itoa(r.to.Size),
+			strconv.Itoa(r.growth()),
+			formatGrowth(r.growth()),
+			rate,
+			formatGrowth(r.perDay()),
+			fast,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// Trend 读取趋势数据，输出每个实例最近n个快照中每个前缀的增长，增长率超过threshold(百分比)的前缀标记为增长过快
+func Trend(store string, instance string, n int, threshold float64, cfg AnalyzeConfig) error {
+	if n < 2 {
+		return errors.New("至少需要对比2个快照")
+	}
+	snapshots, err := loadTrend(store, instance)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		return fmt.Errorf("趋势数据中没有匹配的快照，请先使用 -trend 参数执行分析")
+	}
+
+	fmt.Println("📈 启动趋势分析任务")
+	fmt.Println("==========================================")
+	fmt.Printf("📁 趋势数据: %s\n", store)
+	fmt.Printf("📊 实例数量: %d, 对比最近 %d 个快照, 增长率阈值: %.1f%%\n\n", len(snapshots), n, threshold)
+
+	rows := trendRows(snapshots, n)
+	outputPath, outputFile, err := cfg.CreateOutput("trend", ".csv")
+	if err != nil {
+		return fmt.Errorf("创建输出文件失败: %v", err)
+	}
+	err = writeTrend(outputFile, rows, threshold)
+	_ = outputFile.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  ✅ 完成 -> %s\n", outputPath)
+
+	fast := 0
+	for _, r := range rows {
+		if r.db >= 0 && r.fast(threshold) {
+			fast++
+			if fast <= 20 {
+				fmt.Printf("  ⚠️  %s db%d %s: %s -> %s (%s)\n", r.instance, r.db, r.prefix,
+					bytefmt.FormatSize(uint64(r.from.Size)), bytefmt.FormatSize(uint64(r.to.Size)), formatGrowth(r.growth()))
+			}
+		}
+	}
+
+	fmt.Println("\n📦 正在打包报告文件...")
+	zipPath := generateZipName(cfg.WorkDir, cfg.WorkDirName)
+	if err = compressFiles([]string{outputPath}, zipPath); err != nil {
+		fmt.Printf("❌ 压缩失败: %v\n", err)
+	} else {
+		fmt.Printf("✅ 压缩完成: %s\n", zipPath)
+		cleanupFiles([]string{outputPath})
+	}
+	fmt.Println("==========================================")
+	fmt.Printf("🎉 趋势分析完成，%d 个前缀增长过快\n", fast)
+	return nil
+}
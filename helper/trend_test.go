@@ -0,0 +1,80 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrend(t *testing.T) {
+	dir := t.TempDir()
+	store := filepath.Join(dir, trendStoreName)
+	cfg := AnalyzeConfig{WorkDir: dir, TrendStore: store, Instance: "node1"}
+	days := [][][]string{
+		{
+			{"SET", "user:1", strings.Repeat("x", 100)},
+			{"SET", "cache:1", strings.Repeat("x", 100)},
+		},
+		{
+			{"SET", "user:1", strings.Repeat("x", 100)},
+			{"SET", "cache:1", strings.Repeat("x", 100)},
+			{"SET", "cache:2", strings.Repeat("x", 100)},
+			{"SET", "session:1", "v"},
+		},
+	}
+	start := time.Date(2026, 10, 15, 0, 0, 0, 0, time.Local)
+	for i, cmds := range days {
+		// 同一个实例的两次快照
+		src := writeTestAof(t, dir, "node1.aof", cmds...)
+		recorder := newTrendRecorder(&cfg)
+		recorder.now = start.Add(time.Duration(i) * 48 * time.Hour)
+		if _, _, err := analyseFile(src, []Analyzer{recorder}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	// 解析失败时不追加残缺的快照
+	broken := filepath.Join(dir, "broken.rdb")
+	_ = os.WriteFile(broken, []byte("REDIS0009\xfa"), 0644)
+	if _, _, err := analyseFile(broken, []Analyzer{newTrendRecorder(&cfg)}); err == nil {
+		t.Error("expect parse error")
+	}
+	// 本地文件没有指定实例名称时不能记录趋势
+	if _, err := trendInstance(filepath.Join(dir, "node1.aof"), ""); err == nil {
+		t.Error("expect error for local file without instance")
+	}
+	// 从Redis导出的数据源使用分片标识，与导出的节点无关
+	registerSourceInstance(dumpPath(dir, "10.0.0.2:6379"), "mymaster")
+	if instance, _ := trendInstance(dumpPath(dir, "10.0.0.2:6379"), ""); instance != "mymaster" {
+		t.Errorf("wrong instance of dump: %s", instance)
+	}
+	// 写入中断的行不影响其他快照
+	f, _ := os.OpenFile(store, os.O_APPEND|os.O_WRONLY, 0644)
+	_, _ = f.WriteString("{\"time\":")
+	_ = f.Close()
+
+	snapshots, err := loadTrend(store, "node1")
+	if err != nil || len(snapshots) != 1 || len(snapshots["node1"]) != 2 {
+		t.Errorf("wrong snapshots: %v, %v", snapshots, err)
+		return
+	}
+	rows := make(map[string]*trendRow)
+	for _, r := range trendRows(snapshots, 7) {
+		rows[r.prefix] = r
+	}
+	if len(rows) != 4 || rows["(all)"].to.Count != 4 || rows["user:"].fast(20) {
+		t.Errorf("wrong rows: %v", rows)
+	}
+	cache := rows["cache:"]
+	if cache.rate() != 100 || !cache.fast(20) || cache.fast(100) || cache.perDay() != cache.growth()/2 {
+		t.Errorf("wrong cache growth: rate %f, per day %d", cache.rate(), cache.perDay())
+	}
+	if session := rows["session:"]; session.rate() != -1 || !session.fast(1000) {
+		t.Errorf("new prefix should be flagged: %f", session.rate())
+	}
+	if snapshots, _ := loadTrend(store, "node2"); len(snapshots) != 0 {
+		t.Errorf("instance filter not applied: %v", snapshots)
+	}
+}
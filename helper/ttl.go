@@ -0,0 +1,515 @@
+package helper
+
+import (
+	"container/heap"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hdt3213/rdb/bytefmt"
+	"github.com/hdt3213/rdb/model"
+)
+
+// 过期时间线的时间粒度
+const (
+	TTLByMinute = "minute"
+	TTLByHour   = "hour"
+)
+
+// ttlRanges TTL分布的区间上限，最后一个区间为 >=30天
+var ttlRanges = [...]time.Duration{
+	time.Minute, 10 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour,
+}
+
+var ttlRangeLabels = [...]string{
+	"永久", "已过期", "<1分钟", "1分钟-10分钟", "10分钟-1小时", "1小时-6小时", "6小时-1天", "1天-7天", "7天-30天", ">=30天",
+}
+
+const (
+	ttlPersistent = 0 // ttlStats.ranges中永久KEY的位置
+	ttlExpired    = 1 // 已过期的位置，之后为各TTL区间
+)
+
+type ttlCounter struct {
+	count int
+	size  int
+}
+
+func (c *ttlCounter) add(size int) {
+	c.count++
+	c.size += size
+}
+
+// ttlPrefix 前缀下的KEY总数和永久KEY
+type ttlPrefix struct {
+	db         int
+	prefix     string
+	keyCount   int
+	persistent ttlCounter
+}
+
+// expiredKey 已过期但仍然存储的KEY，Redis惰性删除和定期删除未及时清理
+type expiredKey struct {
+	db         int
+	key        string
+	typ        string
+	size       int
+	expiration time.Time
+	source     string
+}
+
+func (k *expiredKey) GetSize() int {
+	return k.size
+}
+
+// ttlStats 一个或多个数据源的过期统计，可以合并
+type ttlStats struct {
+	ranges   [len(ttlRangeLabels)]ttlCounter
+	prefixes map[string]*ttlPrefix // genKey(db, prefix) -> 统计
+	expired  *topList              // 最大的N个已过期KEY
+	bucket   time.Duration
+	buckets  map[int64]*ttlCounter // 时间段开始(秒) -> 统计，用于时间线
+	peaks    *peakSeconds          // 过期KEY最多的秒，用于找出同一秒集中过期
+}
+
+func newTTLStats(topN int, bucket time.Duration) *ttlStats {
+	return &ttlStats{
+		prefixes: make(map[string]*ttlPrefix),
+		expired:  newToplist(topN),
+		bucket:   bucket,
+		buckets:  make(map[int64]*ttlCounter),
+		peaks:    newPeakSeconds(maxPeakSeconds),
+	}
+}
+
+func (s *ttlStats) prefix(db int, prefix string) *ttlPrefix {
+	p, _ := capGroup(s.prefixes, prefix, func(prefix string) string {
+		return genKey(db, prefix)
+	}, func(prefix string) *ttlPrefix {
+		return &ttlPrefix{db: db, prefix: prefix}
+	})
+	return p
+}
+
+// bucketStart 过期时间所在时间段的开始，按本地时间对齐，与输出的时间一致(时区偏移不是整小时时也正确)
+func bucketStart(sec int64, bucket time.Duration) int64 {
+	t := time.Unix(sec, 0)
+	minute := t.Minute()
+	if bucket >= time.Hour {
+		minute = 0
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), minute, 0, 0, t.Location()).Unix()
+}
+
+func (s *ttlStats) timeBucket(start int64) *ttlCounter {
+	c := s.buckets[start]
+	if c == nil {
+		c = &ttlCounter{}
+		s.buckets[start] = c
+	}
+	return c
+}
+
+// addExpiration 将未过期KEY计入时间线和集中过期统计
+func (s *ttlStats) addExpiration(sec int64, size int) {
+	s.timeBucket(bucketStart(sec, s.bucket)).add(size)
+	s.peaks.add(sec, 1, 0)
+}
+
+func (s *ttlStats) merge(o *ttlStats) {
+	for i := range o.ranges {
+		s.ranges[i].count += o.ranges[i].count
+		s.ranges[i].size += o.ranges[i].size
+	}
+	for _, src := range o.prefixes {
+		p := s.prefix(src.db, src.prefix)
+		p.keyCount += src.keyCount
+		p.persistent.count += src.persistent.count
+		p.persistent.size += src.persistent.size
+	}
+	for _, x := range o.expired.list {
+		if s.expired.accepts(x.GetSize()) {
+			s.expired.add(x)
+		}
+	}
+	for start, src := range o.buckets {
+		c := s.timeBucket(start)
+		c.count += src.count
+		c.size += src.size
+	}
+	for _, p := range o.peaks.items {
+		s.peaks.add(p.sec, p.count, p.overestimate)
+	}
+}
+
+// ttlAnalyzer 过期分析: TTL分布、按前缀统计的永久KEY、已过期未删除的KEY、按分钟或小时的过期时间线
+// 多个数据源时另外输出所有数据源合并后的结果，如集群所有分片同一时间集中过期的KEY
+type ttlAnalyzer struct {
+	cfg        *AnalyzeConfig
+	topN       int
+	separators []string
+	bucket     time.Duration
+	now        time.Time  // 所有数据源使用同一个时间计算TTL
+	mu         sync.Mutex // 保护merged和sources
+	merged     *ttlStats
+	sources    int
+}
+
+func newTTLAnalyzer(cfg *AnalyzeConfig) (Analyzer, error) {
+	if cfg.TopN < 0 {
+		return nil, errors.New("结果数量必须大于0")
+	}
+	topN := cfg.TopN
+	if topN == 0 {
+		topN = 100
+	}
+	bucket := time.Minute
+	switch cfg.TTLBucket {
+	case "", TTLByMinute:
+	case TTLByHour:
+		bucket = time.Hour
+	default:
+		return nil, fmt.Errorf("不支持的时间粒度: %s，可选值: minute, hour", cfg.TTLBucket)
+	}
+	separators := cfg.separators()
+	return &ttlAnalyzer{
+		cfg:        cfg,
+		topN:       topN,
+		separators: separators,
+		bucket:     bucket,
+		now:        time.Now(),
+		merged:     newTTLStats(topN, bucket),
+	}, nil
+}
+
+var ttlSuffixes = []string{"-ttl.csv", "-ttl-persistent.csv", "-ttl-expired.csv", "-ttl-timeline.csv"}
+
+// createOutputs 创建TTL分布、永久KEY前缀、已过期KEY和过期时间线四个结果文件
+func (a *ttlAnalyzer) createOutputs(src string) ([]*os.File, error) {
+	var outputs []*os.File
+	for _, suffix := range ttlSuffixes {
+		_, outputFile, err := a.cfg.CreateOutput(src, suffix)
+		if err != nil {
+			for _, f := range outputs {
+				_ = f.Close()
+			}
+			return nil, fmt.Errorf("创建输出文件失败: %v", err)
+		}
+		outputs = append(outputs, outputFile)
+	}
+	return outputs, nil
+}
+
+func (a *ttlAnalyzer) Begin(rdbFilename string) (FileAnalyzer, error) {
+	// 先创建文件占用文件名，分析完成后再写入
+	outputs, err := a.createOutputs(rdbFilename)
+	if err != nil {
+		return nil, err
+	}
+	return &ttlFileAnalyzer{
+		ttlAnalyzer: a,
+		source:      sourceLabel(rdbFilename),
+		outputs:     outputs,
+		stats:       newTTLStats(a.topN, a.bucket),
+	}, nil
+}
+
+func (a *ttlAnalyzer) Close() ([]string, error) {
+	if a.sources < 2 {
+		return nil, nil
+	}
+	outputs, err := a.createOutputs("all")
+	if err != nil {
+		return nil, err
+	}
+	return a.writeStats(outputs, a.merged, true)
+}
+
+// writeStats 将统计写入各结果文件并关闭
+func (a *ttlAnalyzer) writeStats(outputs []*os.File, stats *ttlStats, withSource bool) ([]string, error) {
+	writers := []func(*csv.Writer) error{
+		stats.writeRanges,
+		func(w *csv.Writer) error { return stats.writePrefixes(w, a.topN) },
+		func(w *csv.Writer) error { return stats.writeExpired(w, withSource) },
+		func(w *csv.Writer) error { return stats.writeTimeline(w) },
+	}
+	var paths []string
+	var err error
+	for i, outputFile := range outputs {
+		paths = append(paths, outputFile.Name())
+		if err == nil {
+			csvWriter := csv.NewWriter(outputFile)
+			if err = writers[i](csvWriter); err == nil {
+				csvWriter.Flush()
+				err = csvWriter.Error()
+			}
+		}
+		_ = outputFile.Close()
+	}
+	return paths, err
+}
+
+type ttlFileAnalyzer struct {
+	*ttlAnalyzer
+	source  string
+	outputs []*os.File
+	stats   *ttlStats
+}
+
+func (fa *ttlFileAnalyzer) Add(object model.RedisObject) {
+	s := fa.stats
+	size := object.GetSize()
+	key := object.GetKey()
+	p := s.prefix(object.GetDBIndex(), key[:nextBoundary(key, fa.separators)])
+	p.keyCount++
+	expiration := object.GetExpiration()
+	if expiration == nil {
+		s.ranges[ttlPersistent].add(size)
+		p.persistent.add(size)
+		return
+	}
+	ttl := expiration.Sub(fa.now)
+	if ttl <= 0 {
+		s.ranges[ttlExpired].add(size)
+		if s.expired.accepts(size) {
+			s.expired.add(&expiredKey{
+				db:         object.GetDBIndex(),
+				key:        key,
+				typ:        object.GetType(),
+				size:       size,
+				expiration: *expiration,
+				source:     fa.source,
+			})
+		}
+		return
+	}
+	i := sort.Search(len(ttlRanges), func(i int) bool {
+		return ttl < ttlRanges[i]
+	})
+	s.ranges[ttlExpired+1+i].add(size)
+	s.addExpiration(expiration.Unix(), size)
+}
+
+func (fa *ttlFileAnalyzer) Finish() ([]string, error) {
+	// 已过期KEY的记录在合并后共享，写入后不再修改
+	paths, err := fa.writeStats(fa.outputs, fa.stats, false)
+	fa.mu.Lock()
+	fa.sources++
+	fa.merged.merge(fa.stats)
+	fa.mu.Unlock()
+	return paths, err
+}
+
+func (s *ttlStats) writeRanges(w *csv.Writer) error {
+	total := 0
+	for _, c := range s.ranges {
+		total += c.count
+	}
+	if err := w.Write([]string{"TTL区间", "KEY个数", "KEY大小", "KEY大小[K/M/G]", "KEY占比"}); err != nil {
+		return err
+	}
+	for i, c := range s.ranges {
+		ratio := 0.0
+		if total > 0 {
+			ratio = float64(c.count) * 100 / float64(total)
+		}
+		err := w.Write([]string{
+			ttlRangeLabels[i],
+			strconv.Itoa(c.count),
+			strconv.Itoa(c.size),
+			bytefmt.FormatSize(uint64(c.size)),
+			strconv.FormatFloat(ratio, 'f', 1, 64) + "%",
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePrefixes 按永久KEY大小输出前topN个前缀
+func (s *ttlStats) writePrefixes(w *csv.Writer, topN int) error {
+	list := make([]*ttlPrefix, 0, len(s.prefixes))
+	for _, p := range s.prefixes {
+		if p.persistent.count > 0 {
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].persistent.size != list[j].persistent.size {
+			return list[i].persistent.size > list[j].persistent.size
+		}
+		if list[i].db != list[j].db {
+			return list[i].db < list[j].db
+		}
+		return list[i].prefix < list[j].prefix
+	})
+	if len(list) > topN {
+		list = list[:topN]
+	}
+	if err := w.Write([]string{"数据库", "前缀", "永久KEY个数", "永久KEY大小", "永久KEY大小[K/M/G]", "KEY个数", "永久KEY占比"}); err != nil {
+		return err
+	}
+	for _, p := range list {
+		err := w.Write([]string{
+			strconv.Itoa(p.db),
+			p.prefix,
+			strconv.Itoa(p.persistent.count),
+			strconv.Itoa(p.persistent.size),
+			bytefmt.FormatSize(uint64(p.persistent.size)),
+			strconv.Itoa(p.keyCount),
+			strconv.FormatFloat(float64(p.persistent.count)*100/float64(p.keyCount), 'f', 1, 64) + "%",
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ttlStats) writeExpired(w *csv.Writer, withSource bool) error {
+	header := []string{"数据库", "KEY名", "KEY类型", "KEY大小", "KEY大小[K/M/G]", "过期时间"}
+	if withSource {
+		header = append(header, "数据源")
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, x := range s.expired.list {
+		k := x.(*expiredKey)
+		row := []string{
+			strconv.Itoa(k.db),
+			k.key,
+			k.typ,
+			strconv.Itoa(k.size),
+			bytefmt.FormatSize(uint64(k.size)),
+			k.expiration.Format(time.RFC3339),
+		}
+		if withSource {
+			row = append(row, k.source)
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTimeline 输出每个时间段的过期KEY，集中过期时间为该时间段内过期KEY最多的一秒，不在跟踪范围内时为空
+func (s *ttlStats) writeTimeline(w *csv.Writer) error {
+	peaks := make(map[int64]*peakSecond)
+	for _, p := range s.peaks.items {
+		start := bucketStart(p.sec, s.bucket)
+		if peak := peaks[start]; peak == nil || p.count > peak.count || p.count == peak.count && p.sec < peak.sec {
+			peaks[start] = p
+		}
+	}
+	starts := make([]int64, 0, len(s.buckets))
+	for start := range s.buckets {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i] < starts[j]
+	})
+	layout := "2006-01-02 15:04"
+	if err := w.Write([]string{"时间", "过期KEY个数", "过期KEY大小", "过期KEY大小[K/M/G]", "集中过期时间", "集中过期KEY个数", "集中过期KEY个数误差上限"}); err != nil {
+		return err
+	}
+	for _, start := range starts {
+		c := s.buckets[start]
+		peakTime, peakCount, peakError := "", "", ""
+		if p := peaks[start]; p != nil {
+			peakTime, peakCount = time.Unix(p.sec, 0).Format(time.DateTime), strconv.Itoa(p.count)
+			peakError = strconv.Itoa(p.overestimate)
+		}
+		err := w.Write([]string{
+			time.Unix(start, 0).Format(layout),
+			strconv.Itoa(c.count),
+			strconv.Itoa(c.size),
+			bytefmt.FormatSize(uint64(c.size)),
+			peakTime,
+			peakCount,
+			peakError,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxPeakSeconds 统计集中过期时最多跟踪的秒数
+var maxPeakSeconds = 10000
+
+// peakSecond 一秒内过期的KEY个数，overestimate为替换其他秒时继承的计数，即计数的误差上限
+type peakSecond struct {
+	sec          int64
+	count        int
+	overestimate int
+	index        int // 在堆中的位置
+}
+
+// peakSeconds 使用Space-Saving算法找出过期KEY最多的秒，内存占用与容量成正比
+// 跟踪的秒数已满时替换计数最少的秒并继承其计数，因此计数是上限的估算，跟踪范围内的秒越集中越准确
+type peakSeconds struct {
+	items    []*peakSecond // 按计数的最小堆
+	index    map[int64]*peakSecond
+	capacity int
+}
+
+func newPeakSeconds(capacity int) *peakSeconds {
+	return &peakSeconds{index: make(map[int64]*peakSecond), capacity: capacity}
+}
+
+func (p *peakSeconds) Len() int {
+	return len(p.items)
+}
+
+func (p *peakSeconds) Less(i, j int) bool {
+	return p.items[i].count < p.items[j].count
+}
+
+func (p *peakSeconds) Swap(i, j int) {
+	p.items[i], p.items[j] = p.items[j], p.items[i]
+	p.items[i].index = i
+	p.items[j].index = j
+}
+
+func (p *peakSeconds) Push(x any) {
+	item := x.(*peakSecond)
+	item.index = len(p.items)
+	p.items = append(p.items, item)
+}
+
+func (p *peakSeconds) Pop() any {
+	item := p.items[len(p.items)-1]
+	p.items = p.items[:len(p.items)-1]
+	return item
+}
+
+func (p *peakSeconds) add(sec int64, n int, overestimate int) {
+	if item := p.index[sec]; item != nil {
+		item.count += n
+		item.overestimate += overestimate
+		heap.Fix(p, item.index)
+		return
+	}
+	if len(p.items) < p.capacity {
+		item := &peakSecond{sec: sec, count: n, overestimate: overestimate}
+		p.index[sec] = item
+		heap.Push(p, item)
+		return
+	}
+	item := p.items[0]
+	delete(p.index, item.sec)
+	item.sec = sec
+	item.overestimate = item.count + overestimate
+	item.count += n
+	p.index[sec] = item
+	heap.Fix(p, 0)
+}
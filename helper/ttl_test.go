@@ -0,0 +1,91 @@
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTTLAnalyse(t *testing.T) {
+	dir := t.TempDir()
+	herd := strconv.FormatInt(time.Now().Add(2*time.Hour).Unix(), 10)
+	src := writeTestAof(t, dir, "node.aof",
+		[]string{"SET", "cache:a", "1"},
+		[]string{"SET", "cache:b", "1", "EX", "30"},
+		[]string{"SET", "session:1", "1", "EXAT", herd},
+		[]string{"SET", "session:2", "1", "EXAT", herd},
+		[]string{"SET", "session:3", "1"},
+		[]string{"EXPIREAT", "session:3", herd},
+		[]string{"SET", "old", "1"},
+		[]string{"EXPIREAT", "old", "1000"},
+	)
+	workDir := filepath.Join(dir, "report")
+	_ = os.MkdirAll(workDir, os.ModePerm)
+	cfg := AnalyzeConfig{WorkDir: workDir, WorkDirName: "report", TTLBucket: TTLByHour}
+	a, err := newTTLAnalyzer(&cfg)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	files, _, err := analyseFile(src, []Analyzer{a})
+	if err != nil || len(files) != 4 {
+		t.Errorf("wrong output: %v, %v", files, err)
+		return
+	}
+	read := func(path string) []string {
+		content, _ := os.ReadFile(path)
+		return strings.Split(strings.TrimSpace(string(content)), "\n")
+	}
+	ranges := read(files[0])
+	if len(ranges) != len(ttlRangeLabels)+1 || !strings.HasPrefix(ranges[1], "永久,1,") ||
+		!strings.HasPrefix(ranges[2], "已过期,1,") || !strings.HasPrefix(ranges[3], "<1分钟,1,") ||
+		!strings.HasPrefix(ranges[6], "1小时-6小时,3,") {
+		t.Errorf("wrong ttl ranges: %v", ranges)
+	}
+	if prefixes := read(files[1]); len(prefixes) != 2 || !strings.HasPrefix(prefixes[1], "0,cache:,1,") ||
+		!strings.HasSuffix(prefixes[1], ",2,50.0%") {
+		t.Errorf("wrong persistent prefixes: %v", prefixes)
+	}
+	if expired := read(files[2]); len(expired) != 2 || !strings.HasPrefix(expired[1], "0,old,string,") {
+		t.Errorf("wrong expired keys: %v", expired)
+	}
+	timeline := read(files[3])
+	if len(timeline) != 3 || !strings.HasSuffix(timeline[2], ","+time.Unix(parseInt([]byte(herd)), 0).Format(time.DateTime)+",3,0") {
+		t.Errorf("wrong timeline: %v", timeline)
+	}
+
+	cfg.TTLBucket = "day"
+	if _, err := newTTLAnalyzer(&cfg); err == nil {
+		t.Error("expect error for unknown bucket")
+	}
+}
+
+func TestBucketStart(t *testing.T) {
+	// 时区偏移不是整小时时，按小时对齐也要与输出的本地时间一致
+	local := time.Local
+	time.Local = time.FixedZone("IST", 5*3600+1800)
+	defer func() { time.Local = local }()
+	sec := time.Date(2024, 3, 1, 10, 47, 10, 0, time.Local).Unix()
+	if got := time.Unix(bucketStart(sec, time.Hour), 0).Format("2006-01-02 15:04"); got != "2024-03-01 10:00" {
+		t.Errorf("wrong hour bucket: %s", got)
+	}
+	if got := time.Unix(bucketStart(sec, time.Minute), 0).Format("2006-01-02 15:04:05"); got != "2024-03-01 10:47:00" {
+		t.Errorf("wrong minute bucket: %s", got)
+	}
+}
+
+func TestPeakSeconds(t *testing.T) {
+	p := newPeakSeconds(2)
+	for _, sec := range []int64{1, 1, 1, 2, 3, 1, 4} {
+		p.add(sec, 1, 0)
+	}
+	// 只跟踪2个秒，集中过期的一秒始终保留
+	// 最后替换进来的秒继承了被替换的计数，误差上限与之相等
+	if len(p.items) != 2 || p.index[1] == nil || p.index[1].count != 4 || p.index[1].overestimate != 0 ||
+		p.index[4] == nil || p.index[4].count-p.index[4].overestimate != 1 {
+		t.Errorf("wrong peaks: %v", p.index)
+	}
+}